@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/archive"
+	"github.com/y1zhou/goduyaoss/pkg/crawler"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+	"github.com/y1zhou/goduyaoss/pkg/metrics"
+	"github.com/y1zhou/goduyaoss/pkg/ocr"
+)
+
+// pipeline sends the images found by the crawler to the OCR queue.
+type pipeline struct {
+	fetcher  *crawler.Fetcher
+	archive  *archive.Archive
+	filter   filter
+	debug    string
+	queue    chan ocr.Job
+	runID    int64
+	failures []failure
+}
+
+func runCrawl(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("crawl", opts)
+	opts.addDBFlags(fs)
+	opts.addWorkersFlag(fs)
+	opts.addCrawlFlags(fs)
+	opts.addMetricsFlag(fs, "")
+	opts.parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	defer lockDB(opts.dbName)()
+	serveMetrics(ctx, opts.metrics)
+	if err := crawl(ctx, opts, opts.sources); err != nil {
+		log.Fatalf("Error starting crawl: %s", err)
+	}
+}
+
+// addCrawlFlags adds the directories used by the crawler.
+func (opts *options) addCrawlFlags(fs *flag.FlagSet) {
+	fs.StringVar(&opts.cacheDir, "cache", "cache", "directory of the HTTP cache")
+	fs.StringVar(&opts.archiveDir, "archive", "archive", "directory of the image archive")
+}
+
+// crawl downloads the images from the sources and runs OCR on the new ones.
+// When ctx is cancelled, no more pages or images are fetched. An error is
+// returned if the crawl couldn't start, e.g. because the database is down;
+// the failures of pages and images are only logged.
+func crawl(ctx context.Context, opts *options, sources []crawler.Source) error {
+	cache, err := crawler.NewCache(opts.cacheDir)
+	if err != nil {
+		return fmt.Errorf("creating cache directory: %s", err)
+	}
+	imgArchive, err := archive.New(opts.archiveDir)
+	if err != nil {
+		return fmt.Errorf("creating archive directory: %s", err)
+	}
+	store, err := db.Open(opts.dbName)
+	if err != nil {
+		return fmt.Errorf("opening database: %s", err)
+	}
+	defer store.Close()
+	runID, err := store.StartRun("crawl")
+	if err != nil {
+		return fmt.Errorf("saving run: %s", err)
+	}
+	log.WithField("run_id", runID).Debug("Started run")
+	defer finishRun(store, runID)
+
+	p := &pipeline{
+		fetcher: crawler.NewFetcher(cache),
+		archive: imgArchive,
+		filter:  opts.filter,
+		debug:   opts.debug,
+		queue:   make(chan ocr.Job, 5),
+		runID:   runID,
+	}
+
+	// Send jobs to the queue
+	var wgCrawler sync.WaitGroup
+	wgCrawler.Add(1)
+	go func() {
+		defer wgCrawler.Done()
+		for _, src := range sources {
+			p.crawlSource(ctx, src)
+		}
+		close(p.queue)
+	}()
+
+	wgWorker := startWorkers(ctx, opts, store, p.queue)
+
+	wgCrawler.Wait()
+	log.Info("Crawler finished!")
+	wgWorker.Wait()
+	if ctx.Err() != nil {
+		log.Warn("Stopped before all jobs were finished")
+	} else {
+		log.Info("All jobs finished!")
+		metrics.LastCrawl.SetToCurrentTime()
+	}
+
+	reportFailures(p.failures)
+	return nil
+}
+
+// fail records a failure to report at the end of the run.
+func (p *pipeline) fail(stage string, netProvider string, provider string, err error) {
+	metrics.Failures.WithLabelValues(stage).Inc()
+	p.failures = append(p.failures, failure{netProvider, provider, err})
+}
+
+// crawlSource queues the images of all providers on the pages of src.
+func (p *pipeline) crawlSource(ctx context.Context, src crawler.Source) {
+	for netProvider, pageURL := range src.Pages() {
+		if ctx.Err() != nil {
+			return
+		}
+		if !p.filter.matchNet(netProvider) {
+			continue
+		}
+		entry := log.WithFields(log.Fields{"net_provider": netProvider, "page_url": pageURL})
+
+		start := time.Now()
+		doc, err := p.fetcher.RequestPage(ctx, pageURL)
+		if err != nil {
+			if ctx.Err() == nil {
+				entry.WithField("stage", metrics.StagePage).Errorf("Skipping page: %s", err)
+				p.fail(metrics.StagePage, netProvider, "", err)
+			}
+			continue
+		}
+		metrics.PagesFetched.Inc()
+		entry.WithFields(log.Fields{
+			"stage":    metrics.StagePage,
+			"duration": time.Since(start).Seconds(),
+		}).Debug("Fetched page")
+
+		providers, err := src.ParseProviders(doc)
+		if err != nil {
+			entry.WithField("stage", metrics.StageParse).Errorf("Skipping page: %s", err)
+			p.fail(metrics.StageParse, netProvider, "", err)
+			continue
+		}
+
+		for _, provider := range providers {
+			targets := provider.Subgroup
+			if provider.Images != nil {
+				targets = []crawler.Provider{provider}
+			}
+
+			for _, target := range targets {
+				if !p.filter.match(netProvider, target.Name) {
+					continue
+				}
+				for _, fig := range target.Images {
+					if ctx.Err() != nil {
+						return
+					}
+					p.queueImage(ctx, src, pageURL, netProvider, target.Name, fig)
+				}
+			}
+		}
+	}
+}
+
+// queueImage downloads and archives a figure, and adds it to the OCR queue.
+func (p *pipeline) queueImage(ctx context.Context, src crawler.Source, pageURL string,
+	netProvider string, provider string, fig crawler.Figure) {
+	job := ocr.Job{
+		NetProvider: netProvider,
+		Provider:    provider,
+		ImageIndex:  fig.Index,
+		URL:         fig.URL,
+		RunID:       p.runID,
+		Debug:       debugJob(p.debug, provider),
+	}
+	entry := job.Log().WithField("stage", metrics.StageImage)
+
+	if !fig.LooksLikeTable() {
+		entry.Infof("Skipping image: %dx%d image is not a result table", fig.Width, fig.Height)
+		return
+	}
+
+	imgURL, err := src.ImageURL(pageURL, fig.URL)
+	if err != nil {
+		entry.Errorf("Skipping image: %s", err)
+		p.fail(metrics.StageImage, netProvider, provider, err)
+		return
+	}
+	job.URL = imgURL
+	entry = entry.WithField("image_url", imgURL)
+
+	start := time.Now()
+	img, err := p.fetcher.FetchImage(ctx, imgURL)
+	if err == crawler.ErrNotModified {
+		entry.Info("Image is unchanged")
+		metrics.ImagesUnchanged.Inc()
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			entry.Errorf("Skipping image: %s", err)
+			p.fail(metrics.StageImage, netProvider, provider, err)
+		}
+		return
+	}
+	duration := time.Since(start)
+	metrics.ImageDuration.Observe(duration.Seconds())
+	metrics.ImageBytes.Add(float64(len(img.Data)))
+	entry.WithFields(log.Fields{"duration": duration.Seconds(), "bytes": len(img.Data)}).Debug("Downloaded image")
+
+	rec, err := p.archive.Save(img.Data, archive.Record{
+		URL:         img.URL,
+		NetProvider: netProvider,
+		Provider:    provider,
+		ImageIndex:  fig.Index,
+		FetchedAt:   time.Now(),
+	})
+	if err != nil {
+		entry.WithField("stage", metrics.StageArchive).Errorf("Error archiving image: %s", err)
+		metrics.Failures.WithLabelValues(metrics.StageArchive).Inc()
+	} else {
+		job.SHA256 = rec.SHA256
+	}
+
+	// The image is only cached once its results are saved, so that it's
+	// fetched again by the next crawl if OCR or the database fails.
+	job.Format = img.Format
+	job.Saved = func() {
+		if err := p.fetcher.CacheImage(img); err != nil {
+			entry.Warnf("Error caching image: %s", err)
+		}
+	}
+	if err := ocr.AddJob(ctx, p.queue, job, img.Image); err != nil && ctx.Err() == nil {
+		entry.WithField("stage", metrics.StageOCR).Errorf("Error queuing image: %s", err)
+		metrics.Failures.WithLabelValues(metrics.StageOCR).Inc()
+	}
+}
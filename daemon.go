@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/crawler"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+	"github.com/y1zhou/goduyaoss/pkg/metrics"
+	"github.com/y1zhou/goduyaoss/pkg/scheduler"
+)
+
+func runDaemon(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("daemon", opts)
+	opts.addDBFlags(fs)
+	opts.addWorkersFlag(fs)
+	opts.addCrawlFlags(fs)
+	opts.addMetricsFlag(fs, "localhost:9299")
+	opts.parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// Hold the lock while the daemon is running, so manual runs don't
+	// write to the database between the scheduled ones
+	defer lockDB(opts.dbName)()
+	serveMetrics(ctx, opts.metrics)
+	daemon(ctx, opts)
+}
+
+// daemon crawls each source on its own schedule until ctx is cancelled.
+// The time of the last run is kept next to the database, so runs missed
+// while the daemon was stopped are started right away.
+func daemon(ctx context.Context, opts *options) {
+	spec := opts.schedule.Cron
+	if spec == "" {
+		spec = scheduler.DefaultSpec
+	}
+	jitter := scheduler.DefaultJitter
+	if opts.schedule.Jitter != nil {
+		jitter = *opts.schedule.Jitter
+	}
+	statePath := opts.schedule.StateFile
+	if statePath == "" && db.IsPostgres(opts.dbName) {
+		statePath = "goduyaoss.schedule.json"
+	} else if statePath == "" {
+		statePath = opts.dbName + ".schedule.json"
+	}
+
+	sched := scheduler.New(statePath, jitter)
+	for _, src := range opts.sources {
+		src := src
+		srcSpec := spec
+		if s, ok := opts.schedules[src.Name()]; ok {
+			srcSpec = s
+		}
+		err := sched.Add(src.Name(), srcSpec, func(ctx context.Context) error {
+			err := crawl(ctx, opts, []crawler.Source{src})
+			if err != nil {
+				metrics.Failures.WithLabelValues(metrics.StageRun).Inc()
+			}
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Error scheduling source: %s", err)
+		}
+		log.WithFields(log.Fields{"source": src.Name(), "schedule": srcSpec}).Info("Scheduled source")
+	}
+
+	if err := sched.Run(ctx); err != nil {
+		log.Fatalf("Error running the scheduler: %s", err)
+	}
+	log.Info("Daemon stopped")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+	"github.com/y1zhou/goduyaoss/pkg/export"
+)
+
+func runExport(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("export", opts)
+	opts.addDBFlags(fs)
+	format := fs.String("format", "csv", "output format ("+strings.Join(export.Formats, ", ")+")")
+	output := fs.String("o", "", "file to write to (default stdout)")
+	from := fs.String("from", "", "only include results from this time on (RFC 3339 time or a date like 2020-12-11)")
+	to := fs.String("to", "", "only include results before this time, or up to the end of this date (RFC 3339 time or a date like 2020-12-11)")
+	latest := fs.Bool("latest", false, "only include the latest results of each provider")
+	opts.parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f := db.ExportFilter{
+		NetProvider: opts.filter.netProvider,
+		Provider:    opts.filter.provider,
+		LatestOnly:  *latest,
+	}
+	var err error
+	if f.From, err = db.ParseTime(*from, false); err != nil {
+		log.Fatalf("Invalid -from: %s", err)
+	}
+	if f.To, err = db.ParseTime(*to, true); err != nil {
+		log.Fatalf("Invalid -to: %s", err)
+	}
+
+	known := false
+	for _, name := range export.Formats {
+		known = known || name == *format
+	}
+	if !known {
+		log.Fatalf("Unknown format %q (should be one of %s)", *format, strings.Join(export.Formats, ", "))
+	}
+
+	store := openReadOnly(opts)
+	start := time.Now()
+	count := 0
+	write := func(out io.Writer) error {
+		w, err := export.New(*format, out)
+		if err != nil {
+			return err
+		}
+		err = store.Export(f, func(r db.Row) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			count++
+			return w.Write(r)
+		})
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	if *output == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFile(*output, write)
+	}
+	store.Close()
+	if err != nil {
+		log.Fatalf("Error exporting results: %s", err)
+	}
+	log.WithFields(log.Fields{
+		"rows":     count,
+		"format":   *format,
+		"duration": time.Since(start).Seconds(),
+	}).Info("Exported results")
+}
+
+// writeFile calls write with a temporary file next to path, which replaces
+// path once write succeeds. An existing file at path is kept if it fails.
+func writeFile(path string, write func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if err == nil {
+		// TempFile creates files that only the owner can read
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
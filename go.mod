@@ -1,14 +1,43 @@
 module github.com/y1zhou/goduyaoss
 
-go 1.15
+go 1.18
 
 require (
 	github.com/PuerkitoBio/goquery v1.6.0
+	github.com/gofrs/flock v0.8.0
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/lib/pq v1.9.0
 	github.com/mattn/go-sqlite3 v1.9.0
 	github.com/otiai10/gosseract v2.2.1+incompatible
-	github.com/otiai10/mint v1.3.2 // indirect
+	github.com/prometheus/client_golang v1.9.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/sirupsen/logrus v1.8.1
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	gocv.io/x/gocv v0.25.0
-	golang.org/x/net v0.0.0-20201209123823-ac852fbbde11 // indirect
-	google.golang.org/appengine v1.6.7 // indirect
+	golang.org/x/image v0.18.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/andybalholm/cascadia v1.1.0 // indirect
+	github.com/apache/arrow/go/arrow v0.0.0-20200730104253-651201b0f516 // indirect
+	github.com/apache/thrift v0.14.2 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/golang/snappy v0.0.3 // indirect
+	github.com/klauspost/compress v1.13.1 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/otiai10/mint v1.3.2 // indirect
+	github.com/pierrec/lz4/v4 v4.1.8 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.15.0 // indirect
+	github.com/prometheus/procfs v0.2.0 // indirect
+	golang.org/x/crypto v0.23.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+	google.golang.org/protobuf v1.23.0 // indirect
 )
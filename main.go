@@ -1,62 +1,361 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
 	"sync"
+	"syscall"
 
+	"github.com/gofrs/flock"
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/config"
 	"github.com/y1zhou/goduyaoss/pkg/crawler"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+	"github.com/y1zhou/goduyaoss/pkg/logging"
 	"github.com/y1zhou/goduyaoss/pkg/ocr"
+	"github.com/y1zhou/goduyaoss/pkg/ocr/tesseract"
 )
 
+// command is a subcommand of the CLI.
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(ctx context.Context, args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"crawl", "", "download new result images and save the OCR results", runCrawl},
+		{"ocr", "<image>", "run OCR on a local image and print the table", runOCR},
+		{"reprocess", "<dir>...", "run OCR again on saved images and replace their results", runReprocess},
+		{"reparse", "", "parse the saved OCR text again and update the results", runReparse},
+		{"query", "", "print the latest results of each provider", runQuery},
+		{"export", "", "export results as CSV, JSON Lines or Parquet", runExport},
+		{"daemon", "", "keep running and crawl the sources on a schedule", runDaemon},
+		{"serve", "", "serve the web dashboard and a read-only JSON API", runServe},
+		{"migrate", "", "upgrade the database schema to the latest version", runMigrate},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n"+
+		"Without a command, crawl is run with the default flags.\n", os.Args[0])
+}
+
 func main() {
-	dbName := "test.db"
+	ctx, stop := signalContext()
+	defer stop()
 
-	queue := make(chan ocr.Job, 5)
+	// Keep the old behavior of crawling when no command is given
+	if len(os.Args) < 2 {
+		runCrawl(ctx, nil)
+		return
+	}
 
-	// Send jobs to the queue
-	var wgCrawler sync.WaitGroup
-	wgCrawler.Add(1)
-	go func() {
-		defer wgCrawler.Done()
-		for netProvider, url := range crawler.Pages {
-			doc := crawler.RequestPage(url)
-			providers := crawler.FetchProviders(doc)
-
-			for _, provider := range providers {
-				if provider.ImgURL != "" {
-					img := crawler.FetchImage(provider.ImgURL)
-					ocr.AddJob(queue, img, netProvider, provider.Name)
-
-					log.Printf("[main] %s -> %s added to queue\n",
-						netProvider, provider.Name)
-				} else {
-					for _, subProvider := range provider.Subgroup {
-						img := crawler.FetchImage(subProvider.ImgURL)
-						ocr.AddJob(queue, img, netProvider, subProvider.Name)
-
-						log.Printf("[main] %s -> %s added to queue\n",
-							netProvider, subProvider.Name)
-					}
-				}
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(ctx, os.Args[2:])
+			return
+		}
+	}
+
+	if name != "-h" && name != "-help" && name != "--help" && name != "help" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
+
+// options are the flags shared by the commands. Flags that aren't set on
+// the command line are taken from the config file.
+type options struct {
+	configPath string
+	dbName     string
+	workers    int
+	cacheDir   string
+	archiveDir string
+	listen     string
+	metrics    string
+	debug      string
+	logLevel   string
+	logFormat  string
+	filter     filter
+	sources    []crawler.Source
+	schedule   config.Schedule
+	// schedules maps source names to their own cron spec
+	schedules map[string]string
+}
+
+// newFlagSet creates the flags of a command, starting with -config.
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			usage := strings.TrimSpace(fmt.Sprintf("%s %s [flags] %s", os.Args[0], cmd.name, cmd.args))
+			help := strings.ToUpper(cmd.short[:1]) + cmd.short[1:]
+			fs.Usage = func() {
+				fmt.Fprintf(os.Stderr, "Usage: %s\n\n%s.\n\nFlags:\n", usage, help)
+				fs.PrintDefaults()
+			}
+		}
+	}
+
+	fs.StringVar(&opts.configPath, "config", "",
+		fmt.Sprintf("path to the config file (default %q if it exists)", config.DefaultPath))
+	fs.StringVar(&opts.logLevel, "log-level", "info", "minimum level of log records (debug, info, warn or error)")
+	fs.StringVar(&opts.logFormat, "log-format", "json", "format of log records (json or text)")
+	return fs
+}
+
+// addDBFlags adds the database path and the filters.
+func (opts *options) addDBFlags(fs *flag.FlagSet) {
+	fs.StringVar(&opts.dbName, "db", "test.db", "path to the SQLite database or a postgres:// URL")
+	fs.StringVar(&opts.filter.netProvider, "net", "", "only include this net provider (移动/联通/电信)")
+	fs.StringVar(&opts.filter.provider, "provider", "", "only include providers whose name contains this text")
+}
+
+// addWorkersFlag adds the flags of the commands that run OCR jobs.
+func (opts *options) addWorkersFlag(fs *flag.FlagSet) {
+	fs.IntVar(&opts.workers, "workers", defaultWorkers(), "number of OCR workers")
+	fs.StringVar(&opts.debug, "debug", "", "log debug output for the jobs of providers whose name contains this text")
+}
+
+// addMetricsFlag adds the address of the Prometheus metrics endpoint.
+func (opts *options) addMetricsFlag(fs *flag.FlagSet, value string) {
+	fs.StringVar(&opts.metrics, "metrics", value, "address to serve Prometheus metrics on at /metrics (empty to disable)")
+}
+
+// parse parses the command line and loads the config file.
+func (opts *options) parse(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+
+	path := opts.configPath
+	if path == "" {
+		if _, err := os.Stat(config.DefaultPath); err == nil {
+			path = config.DefaultPath
+		}
+	}
+	cfg := new(config.Config)
+	if path != "" {
+		var err error
+		if cfg, err = config.Load(path); err != nil {
+			log.Fatalf("Error loading config: %s", err)
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if cfg.Log.Level != "" && !set["log-level"] {
+		opts.logLevel = cfg.Log.Level
+	}
+	if cfg.Log.Format != "" && !set["log-format"] {
+		opts.logFormat = cfg.Log.Format
+	}
+	if err := logging.Setup(opts.logLevel, opts.logFormat); err != nil {
+		log.Fatalf("Error setting up logging: %s", err)
+	}
+
+	if cfg.Database != "" && !set["db"] {
+		opts.dbName = cfg.Database
+	}
+	if cfg.Workers > 0 && !set["workers"] {
+		opts.workers = cfg.Workers
+	}
+	if cfg.CacheDir != "" && !set["cache"] {
+		opts.cacheDir = cfg.CacheDir
+	}
+	if cfg.ArchiveDir != "" && !set["archive"] {
+		opts.archiveDir = cfg.ArchiveDir
+	}
+	if cfg.Listen != "" && !set["listen"] {
+		opts.listen = cfg.Listen
+	}
+	if cfg.MetricsListen != "" && !set["metrics"] {
+		opts.metrics = cfg.MetricsListen
+	}
+
+	opts.sources = crawler.Sources
+	opts.schedules = make(map[string]string)
+	if len(cfg.Sources) > 0 {
+		opts.sources = nil
+		for _, c := range cfg.Sources {
+			src, err := crawler.NewSource(c.Type, c.Name, c.Pages)
+			if err != nil {
+				log.Fatalf("Error in config source %q: %s", c.Name, err)
+			}
+			opts.sources = append(opts.sources, src)
+			if c.Schedule != "" {
+				opts.schedules[c.Name] = c.Schedule
 			}
 		}
-		close(queue)
+	}
+	opts.schedule = cfg.Schedule
+
+	ocr.SetLayout(ocr.Layout{
+		RowHeight:        cfg.OCR.RowHeight,
+		ColWidthAvgSpeed: cfg.OCR.ColWidthAvgSpeed,
+		ColWidthUDPNAT:   cfg.OCR.ColWidthUDPNAT,
+	})
+	if err := ocr.SetCharWhitelist(cfg.OCR.CharWhitelist); err != nil {
+		log.Fatalf("Error in config: %s", err)
+	}
+}
+
+// filter selects the net providers and providers to process.
+type filter struct {
+	netProvider string
+	provider    string
+}
+
+func (f filter) matchNet(netProvider string) bool {
+	return f.netProvider == "" || f.netProvider == netProvider
+}
+
+func (f filter) match(netProvider string, provider string) bool {
+	return f.matchNet(netProvider) &&
+		strings.Contains(strings.ToLower(provider), strings.ToLower(f.provider))
+}
+
+// debugJob reports whether the debug output of a job is turned on by
+// -debug, which matches parts of provider names.
+func debugJob(pattern string, provider string) bool {
+	return pattern != "" && strings.Contains(strings.ToLower(provider), strings.ToLower(pattern))
+}
+
+// failure records a page or provider that was skipped because of an error.
+type failure struct {
+	netProvider string
+	provider    string
+	err         error
+}
+
+// lockDB takes the single-instance lock next to the database, so that two
+// runs never write to it at the same time. The returned function releases it.
+// PostgreSQL handles concurrent writers, so no lock is taken for it.
+func lockDB(dbName string) func() {
+	if db.IsPostgres(dbName) {
+		return func() {}
+	}
+	lock := flock.New(dbName + ".lock")
+	locked, err := lock.TryLock()
+	if err != nil {
+		log.Fatalf("Error locking %s: %s", lock.Path(), err)
+	}
+	if !locked {
+		log.Fatalf("Another instance is writing to %s (locked by %s)", dbName, lock.Path())
+	}
+	return func() { lock.Unlock() }
+}
+
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM,
+// e.g. when systemd stops the service. A second signal exits immediately.
+func signalContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Warnf("Received %s, finishing the jobs in progress", sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		sig := <-sigs
+		log.Fatalf("Received %s again, exiting", sig)
 	}()
 
-	// Each Tesseract process uses a maximum of 4 threads
-	// https://github.com/tesseract-ocr/tesseract/issues/1600
-	numWorkers := runtime.NumCPU() / 4
-	log.Printf("Spawning %d workers\n", numWorkers)
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// defaultWorkers returns the number of OCR workers to run in parallel.
+// Each Tesseract process uses a maximum of 4 threads
+// https://github.com/tesseract-ocr/tesseract/issues/1600
+func defaultWorkers() int {
+	if n := runtime.NumCPU() / 4; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// openStore connects to the database of opts.
+func openStore(opts *options) db.Store {
+	store, err := db.Open(opts.dbName)
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	return store
+}
+
+// openReadOnly connects to the existing database of opts, for commands that
+// only read it.
+func openReadOnly(opts *options) db.Store {
+	store, err := db.OpenReadOnly(opts.dbName)
+	if err != nil {
+		log.Fatalf("Error opening database %s: %s", opts.dbName, err)
+	}
+	return store
+}
+
+// startRun records the start of a command in the database.
+func startRun(store db.Store, command string) int64 {
+	id, err := store.StartRun(command)
+	if err != nil {
+		log.Fatalf("Error saving run: %s", err)
+	}
+	log.WithField("run_id", id).Debug("Started run")
+	return id
+}
+
+// finishRun records the end of a run.
+func finishRun(store db.Store, id int64) {
+	if err := store.FinishRun(id); err != nil {
+		log.WithField("run_id", id).Errorf("Error saving run: %s", err)
+	}
+}
+
+// startWorkers spawns the OCR workers that consume the queue and save the
+// results to store. Each worker has its own Tesseract client.
+func startWorkers(ctx context.Context, opts *options, store db.Store, queue chan ocr.Job) *sync.WaitGroup {
+	numWorkers := opts.workers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	log.WithField("workers", numWorkers).Info("Spawning workers")
 
 	var wgWorker sync.WaitGroup
 	for w := 0; w < numWorkers; w++ {
 		wgWorker.Add(1)
-		go ocr.Worker(w+1, dbName, queue, &wgWorker)
+		go ocr.Worker(ctx, w+1, store, tesseract.New(), queue, &wgWorker)
 	}
+	return &wgWorker
+}
 
-	wgCrawler.Wait()
-	log.Printf("Crawler finished!")
-	wgWorker.Wait()
-	log.Printf("All jobs finished!")
+func reportFailures(failures []failure) {
+	if len(failures) > 0 {
+		log.Warnf("%d failures during the run", len(failures))
+		for _, f := range failures {
+			log.WithFields(log.Fields{
+				"net_provider": f.netProvider,
+				"provider":     f.provider,
+			}).Warnf("Failed: %s", f.err)
+		}
+	}
 }
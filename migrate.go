@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+func runMigrate(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("migrate", opts)
+	fs.StringVar(&opts.dbName, "db", "test.db", "path to the SQLite database or a postgres:// URL")
+	status := fs.Bool("status", false, "print the schema version and the pending migrations without applying them")
+	opts.parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *status {
+		printStatus(opts.dbName)
+		return
+	}
+
+	defer lockDB(opts.dbName)()
+	applied, err := db.Migrate(opts.dbName)
+	if err != nil {
+		log.Fatalf("Error migrating database: %s", err)
+	}
+	if len(applied) == 0 {
+		log.WithField("version", db.LatestVersion).Info("Database is up to date")
+	}
+}
+
+// printStatus prints the schema version of the database and the migrations
+// that the migrate command would apply.
+func printStatus(dbName string) {
+	version, pending, err := db.Status(dbName)
+	if err != nil {
+		log.Fatalf("Error reading schema version: %s", err)
+	}
+	fmt.Printf("Schema version: %d (latest: %d)\n", version, db.LatestVersion)
+	for _, m := range pending {
+		fmt.Printf("Pending: %d %s\n", m.Version, m.Name)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/ocr"
+	"github.com/y1zhou/goduyaoss/pkg/ocr/tesseract"
+)
+
+func runOCR(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("ocr", opts)
+	opts.parse(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	img, _, err := ocr.ReadImage(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer img.Close()
+
+	engine := tesseract.New()
+	defer engine.Close()
+
+	entry := log.WithField("image_url", fs.Arg(0))
+	timestamp, err := ocr.GetMetadata(engine, img, entry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tbl, err := ocr.ImgToTable(engine, img, entry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Generated at %s\n\n", timestamp.Format("2006-01-02 15:04:05"))
+	if err := ocr.PrintTable(tbl); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+// Server is a read-only JSON API over the results database.
+//
+//	GET /api/net-providers
+//	GET /api/providers?net=
+//	GET /api/latest?net=&provider=&remarks=&nat=&min_speed=
+//	GET /api/history?net=&provider=&remarks=&from=&to=
+type Server struct {
+	store db.Store
+	mux   *http.ServeMux
+}
+
+// New creates the API for the results in store.
+func New(store db.Store) *Server {
+	s := &Server{store: store, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/api/net-providers", s.netProviders)
+	s.mux.HandleFunc("/api/providers", s.providers)
+	s.mux.HandleFunc("/api/latest", s.latest)
+	s.mux.HandleFunc("/api/history", s.history)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) netProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := s.store.Providers()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	netProviders := []string{}
+	for i, p := range providers {
+		if i == 0 || p.NetProvider != providers[i-1].NetProvider {
+			netProviders = append(netProviders, p.NetProvider)
+		}
+	}
+	writeJSON(w, netProviders)
+}
+
+func (s *Server) providers(w http.ResponseWriter, r *http.Request) {
+	providers, err := s.store.Providers()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	netProvider := r.URL.Query().Get("net")
+	res := []db.Provider{}
+	for _, p := range providers {
+		if netProvider == "" || p.NetProvider == netProvider {
+			res = append(res, p)
+		}
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) latest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := db.Filter{
+		NetProvider: q.Get("net"),
+		Provider:    q.Get("provider"),
+		Remarks:     q.Get("remarks"),
+		UDPNATType:  q.Get("nat"),
+	}
+	if v := q.Get("min_speed"); v != "" {
+		speed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid min_speed %q", v))
+			return
+		}
+		f.MinSpeed = speed
+	}
+
+	rows, err := s.store.Latest(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeRows(w, rows)
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	netProvider, provider, remarks := q.Get("net"), q.Get("provider"), q.Get("remarks")
+	if netProvider == "" || provider == "" || remarks == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("net, provider and remarks are required"))
+		return
+	}
+	from, err := db.ParseTime(q.Get("from"), false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %s", err))
+		return
+	}
+	to, err := db.ParseTime(q.Get("to"), true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid to: %s", err))
+		return
+	}
+
+	rows, err := s.store.History(netProvider, provider, remarks, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeRows(w, rows)
+}
+
+// writeRows writes an empty array instead of null when nothing matched.
+func writeRows(w http.ResponseWriter, rows []db.Row) {
+	if rows == nil {
+		rows = []db.Row{}
+	}
+	writeJSON(w, rows)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error writing response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	if code >= http.StatusInternalServerError {
+		log.Error(err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
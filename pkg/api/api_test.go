@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+var timestamp = time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+
+// newTestServer fills an in-memory database, which lives as long as the
+// store is open.
+func newTestServer(t *testing.T) (*Server, func()) {
+	store, err := db.Open("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tbl := [][]string{
+		{"Group", "Group"},
+		{"HK 01", "JP 01"},
+		{"0.00%", "1.00%"},
+		{"5213", "6021"},
+		{"14452", "15037"},
+		{"21.48MB", "9.15MB"},
+		{"Full Cone", "Symmetric"},
+	}
+	snapshots := []db.Snapshot{
+		{NetProvider: "联通", Provider: "ssrcloud", Timestamp: timestamp.Add(-48 * time.Hour), Table: tbl},
+		{NetProvider: "联通", Provider: "ssrcloud", Timestamp: timestamp.Add(-24 * time.Hour), Table: tbl},
+		{NetProvider: "联通", Provider: "ssrcloud", Timestamp: timestamp, Table: tbl},
+		{NetProvider: "电信", Provider: "N3RO", Timestamp: timestamp, Table: tbl},
+	}
+	for _, snap := range snapshots {
+		if err := store.InsertSnapshot(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return New(store), func() { store.Close() }
+}
+
+func get(t *testing.T, s *Server, path string, query url.Values, v interface{}) int {
+	req := httptest.NewRequest("GET", path+"?"+query.Encode(), nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("Error decoding %s: %s", path, err)
+	}
+	return rec.Code
+}
+
+func TestProviders(t *testing.T) {
+	s, done := newTestServer(t)
+	defer done()
+
+	var netProviders []string
+	get(t, s, "/api/net-providers", nil, &netProviders)
+	if len(netProviders) != 2 {
+		t.Errorf("Net providers are %v", netProviders)
+	}
+
+	var providers []db.Provider
+	get(t, s, "/api/providers", url.Values{"net": {"联通"}}, &providers)
+	if len(providers) != 1 {
+		t.Fatalf("Providers of 联通 are %+v", providers)
+	}
+	p := providers[0]
+	if p.Provider != "ssrcloud" || p.Snapshots != 3 || p.Images != 1 || !p.LastUpdated.Equal(timestamp) {
+		t.Errorf("Provider is %+v", p)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	s, done := newTestServer(t)
+	defer done()
+
+	cases := []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{}, 4},
+		{url.Values{"net": {"联通"}}, 2},
+		{url.Values{"remarks": {"hk"}}, 2},
+		{url.Values{"nat": {"full cone"}}, 2},
+		{url.Values{"min_speed": {"1e7"}}, 2},
+		{url.Values{"provider": {"n3ro"}, "remarks": {"JP"}, "min_speed": {"1e7"}}, 0},
+	}
+	for _, c := range cases {
+		var rows []db.Row
+		if code := get(t, s, "/api/latest", c.query, &rows); code != http.StatusOK {
+			t.Errorf("Status for %v is %d", c.query, code)
+		}
+		if len(rows) != c.want {
+			t.Errorf("Found %d rows for %v, should be %d", len(rows), c.query, c.want)
+		}
+	}
+
+	var res map[string]string
+	if code := get(t, s, "/api/latest", url.Values{"min_speed": {"fast"}}, &res); code != http.StatusBadRequest {
+		t.Errorf("Status for an invalid speed is %d", code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s, done := newTestServer(t)
+	defer done()
+
+	query := url.Values{"net": {"联通"}, "provider": {"ssrcloud"}, "remarks": {"HK 01"}}
+	var rows []db.Row
+	get(t, s, "/api/history", query, &rows)
+	if len(rows) != 3 || !rows[2].Timestamp.Equal(timestamp) {
+		t.Fatalf("History is %+v", rows)
+	}
+
+	query.Set("from", timestamp.Add(-30*time.Hour).Format(time.RFC3339))
+	query.Set("to", timestamp.Add(-time.Hour).Format(time.RFC3339))
+	get(t, s, "/api/history", query, &rows)
+	if len(rows) != 1 || !rows[0].Timestamp.Equal(timestamp.Add(-24*time.Hour)) {
+		t.Errorf("History between %s and %s is %+v", query.Get("from"), query.Get("to"), rows)
+	}
+
+	var res map[string]string
+	if code := get(t, s, "/api/history", url.Values{"net": {"联通"}}, &res); code != http.StatusBadRequest {
+		t.Errorf("Status without a node is %d", code)
+	}
+}
@@ -0,0 +1,161 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Record describes an archived image. It's saved as a JSON sidecar file
+// next to the image.
+type Record struct {
+	SHA256      string    `json:"sha256"`
+	URL         string    `json:"url"`
+	NetProvider string    `json:"net_provider"`
+	Provider    string    `json:"provider"`
+	ImageIndex  int       `json:"image_index"`
+	FetchedAt   time.Time `json:"fetched_at"`
+
+	// Path is the location of the image file. It's filled in when reading
+	// the archive and isn't saved in the sidecar.
+	Path string `json:"-"`
+}
+
+// Archive stores raw images keyed by their SHA-256 hash. Images are split
+// into subdirectories by the first two characters of the hash:
+//
+//	<dir>/ab/abcdef...png
+//	<dir>/ab/abcdef...json
+type Archive struct {
+	dir string
+}
+
+// New opens the archive in dir, creating the directory if needed.
+func New(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Archive{dir: dir}, nil
+}
+
+// Open opens the existing archive in dir, for reading it without creating
+// the directory.
+func Open(dir string) (*Archive, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", dir)
+	}
+	return &Archive{dir: dir}, nil
+}
+
+// extensions are the file extensions of the images in the archive, by the
+// content type detected from their first bytes.
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+}
+
+// fileExt guesses the file extension from the first bytes of the image.
+func fileExt(data []byte) string {
+	if ext, ok := extensions[http.DetectContentType(data)]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// IsImage reports whether path has the extension of an image that Save
+// writes.
+func IsImage(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the image and its sidecar record to the archive, and returns
+// the record with `SHA256` and `Path` filled in. Images that are already in
+// the archive keep their original record.
+func (a *Archive) Save(data []byte, rec Record) (Record, error) {
+	sum := sha256.Sum256(data)
+	rec.SHA256 = hex.EncodeToString(sum[:])
+
+	dir := filepath.Join(a.dir, rec.SHA256[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return rec, err
+	}
+	rec.Path = filepath.Join(dir, rec.SHA256+fileExt(data))
+	sidecar := filepath.Join(dir, rec.SHA256+".json")
+
+	if existing, err := readRecord(sidecar); err == nil {
+		existing.Path = rec.Path
+		return existing, nil
+	}
+
+	if err := ioutil.WriteFile(rec.Path, data, 0644); err != nil {
+		return rec, err
+	}
+	meta, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return rec, err
+	}
+	return rec, ioutil.WriteFile(sidecar, meta, 0644)
+}
+
+// Sidecar returns the record saved next to an archived image.
+func Sidecar(imgPath string) (Record, error) {
+	rec, err := readRecord(strings.TrimSuffix(imgPath, filepath.Ext(imgPath)) + ".json")
+	rec.Path = imgPath
+	return rec, err
+}
+
+func readRecord(path string) (Record, error) {
+	var rec Record
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// Get returns the record of the image with the given hash.
+func (a *Archive) Get(sha string) (Record, error) {
+	if len(sha) != sha256.Size*2 {
+		return Record{}, os.ErrNotExist
+	}
+	if _, err := hex.DecodeString(sha); err != nil {
+		return Record{}, os.ErrNotExist
+	}
+
+	sidecar := filepath.Join(a.dir, sha[:2], sha+".json")
+	rec, err := readRecord(sidecar)
+	if err != nil {
+		return rec, err
+	}
+	matches, err := filepath.Glob(filepath.Join(a.dir, sha[:2], sha+".*"))
+	if err != nil {
+		return rec, err
+	}
+	for _, m := range matches {
+		if m != sidecar {
+			rec.Path = m
+		}
+	}
+	return rec, nil
+}
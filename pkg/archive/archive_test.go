@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/image/bmp"
+)
+
+func TestSaveAndGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goduyaoss-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	png.Encode(buf, image.NewGray(image.Rect(0, 0, 20, 10)))
+	rec := Record{
+		URL:         "https://example.com/result.png",
+		NetProvider: "联通",
+		Provider:    "ssrcloud",
+		FetchedAt:   time.Date(2020, 12, 12, 3, 0, 0, 0, time.UTC),
+	}
+
+	saved, err := a.Save(buf.Bytes(), rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.SHA256) != 64 {
+		t.Errorf("SHA256 is %q", saved.SHA256)
+	}
+
+	// Saving the same image again keeps the first record
+	again, err := a.Save(buf.Bytes(), Record{URL: "https://example.com/copy.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.URL != rec.URL || again.Path != saved.Path {
+		t.Errorf("Record of existing image changed to %+v", again)
+	}
+
+	got, err := a.Get(saved.SHA256)
+	if err != nil || got.Path != saved.Path || got.Provider != "ssrcloud" {
+		t.Errorf("Get returned %+v, %v", got, err)
+	}
+	data, err := ioutil.ReadFile(got.Path)
+	if err != nil || !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("Archived image doesn't match the original")
+	}
+	if _, err := a.Get("../../etc/passwd"); !os.IsNotExist(err) {
+		t.Errorf("Get of an invalid hash returned %v", err)
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	gifImage, bmpImage := new(bytes.Buffer), new(bytes.Buffer)
+	gif.Encode(gifImage, img, nil)
+	bmp.Encode(bmpImage, img)
+
+	// Every format that's saved is found again by reprocess
+	for _, data := range [][]byte{gifImage.Bytes(), bmpImage.Bytes()} {
+		if ext := fileExt(data); !IsImage("image" + ext) {
+			t.Errorf("%s files aren't images", ext)
+		}
+	}
+	if IsImage("image.json") || IsImage("image.bin") {
+		t.Error("Sidecars or unknown files are images")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goduyaoss-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Open(dir); err != nil {
+		t.Errorf("Error opening an existing archive: %s", err)
+	}
+	missing := filepath.Join(dir, "missing")
+	if _, err := Open(missing); !os.IsNotExist(err) {
+		t.Errorf("Opening a missing archive returned %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Opening a missing archive created it: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultPath is the config file that is loaded if no path is given.
+const DefaultPath = "goduyaoss.yaml"
+
+// Config holds the settings that used to be compiled into the binary. Zero
+// values mean the built-in defaults are used.
+type Config struct {
+	Database      string   `yaml:"database"`
+	CacheDir      string   `yaml:"cache_dir"`
+	ArchiveDir    string   `yaml:"archive_dir"`
+	Workers       int      `yaml:"workers"`
+	Listen        string   `yaml:"listen"`
+	MetricsListen string   `yaml:"metrics_listen"`
+	Sources       []Source `yaml:"sources"`
+	OCR           OCR      `yaml:"ocr"`
+	Schedule      Schedule `yaml:"schedule"`
+	Log           Log      `yaml:"log"`
+}
+
+// Source is a website to crawl. Type picks the parser, e.g. "duyaoss" for
+// sites using the same WordPress theme as www.duyaoss.com.
+type Source struct {
+	Name  string            `yaml:"name"`
+	Type  string            `yaml:"type"`
+	Pages map[string]string `yaml:"pages"` // net provider -> page URL
+	// Schedule overrides Schedule.Cron for this source in daemon mode.
+	Schedule string `yaml:"schedule"`
+}
+
+// OCR holds the geometry of the SSRSpeed result tables and the characters
+// Tesseract may return for each column.
+type OCR struct {
+	RowHeight        int               `yaml:"row_height"`
+	ColWidthAvgSpeed int               `yaml:"col_width_avg_speed"`
+	ColWidthUDPNAT   int               `yaml:"col_width_udp_nat"`
+	CharWhitelist    map[string]string `yaml:"char_whitelist"`
+}
+
+// Schedule controls when the daemon crawls the sources. Cron is a standard
+// 5-field cron expression or a descriptor such as "@every 12h". Jitter is
+// a pointer so that 0 can turn it off.
+type Schedule struct {
+	Cron      string         `yaml:"cron"`
+	Jitter    *time.Duration `yaml:"jitter"`
+	StateFile string         `yaml:"state_file"`
+}
+
+// Log sets the level ("debug", "info", "warn" or "error") and format
+// ("json" or "text") of the log records.
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Load reads and validates a config file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes and validates a YAML config. Unknown keys are errors, so
+// typos don't silently fall back to the defaults.
+func Parse(data []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the values that can be checked without the other packages.
+func (cfg *Config) Validate() error {
+	if cfg.Workers < 0 {
+		return fmt.Errorf("workers must not be negative, got %d", cfg.Workers)
+	}
+
+	names := make(map[string]bool)
+	for i, src := range cfg.Sources {
+		if src.Name == "" {
+			return fmt.Errorf("sources[%d]: name is required", i)
+		}
+		if names[src.Name] {
+			return fmt.Errorf("sources[%d]: duplicate name %q", i, src.Name)
+		}
+		names[src.Name] = true
+
+		if src.Type == "" {
+			return fmt.Errorf("source %q: type is required", src.Name)
+		}
+		if len(src.Pages) == 0 {
+			return fmt.Errorf("source %q: no pages", src.Name)
+		}
+		for netProvider, page := range src.Pages {
+			u, err := url.Parse(page)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("source %q: invalid URL for %s: %q", src.Name, netProvider, page)
+			}
+		}
+		if src.Schedule != "" {
+			if _, err := cron.ParseStandard(src.Schedule); err != nil {
+				return fmt.Errorf("source %q: invalid schedule: %s", src.Name, err)
+			}
+		}
+	}
+
+	if cfg.Schedule.Cron != "" {
+		if _, err := cron.ParseStandard(cfg.Schedule.Cron); err != nil {
+			return fmt.Errorf("schedule.cron: %s", err)
+		}
+	}
+	if cfg.Schedule.Jitter != nil && *cfg.Schedule.Jitter < 0 {
+		return fmt.Errorf("schedule.jitter must not be negative, got %s", *cfg.Schedule.Jitter)
+	}
+
+	switch cfg.Log.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level must be debug, info, warn or error, got %q", cfg.Log.Level)
+	}
+	switch cfg.Log.Format {
+	case "", "json", "text":
+	default:
+		return fmt.Errorf("log.format must be json or text, got %q", cfg.Log.Format)
+	}
+
+	geometry := map[string]int{
+		"row_height":          cfg.OCR.RowHeight,
+		"col_width_avg_speed": cfg.OCR.ColWidthAvgSpeed,
+		"col_width_udp_nat":   cfg.OCR.ColWidthUDPNAT,
+	}
+	for key, value := range geometry {
+		if value < 0 {
+			return fmt.Errorf("ocr.%s must not be negative, got %d", key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+database: /var/lib/goduyaoss/results.db
+workers: 2
+sources:
+  - name: duyaoss
+    type: duyaoss
+    pages:
+      联通: https://www.duyaoss.com/archives/3/
+ocr:
+  row_height: 32
+  char_whitelist:
+    loss: "0123456789%."
+schedule:
+  cron: "@every 12h"
+  jitter: 5m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Database != "/var/lib/goduyaoss/results.db" || cfg.Workers != 2 {
+		t.Errorf("Config is %+v", cfg)
+	}
+	if len(cfg.Sources) != 1 || cfg.Sources[0].Pages["联通"] != "https://www.duyaoss.com/archives/3/" {
+		t.Errorf("Sources are %+v", cfg.Sources)
+	}
+	if cfg.OCR.RowHeight != 32 || cfg.OCR.ColWidthUDPNAT != 0 {
+		t.Errorf("OCR settings are %+v", cfg.OCR)
+	}
+	if cfg.Schedule.Cron != "@every 12h" || cfg.Schedule.Jitter == nil || *cfg.Schedule.Jitter != 5*time.Minute {
+		t.Errorf("Schedule is %+v", cfg.Schedule)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := map[string]string{
+		"unknown key":  "databse: test.db",
+		"no pages":     "sources: [{name: a, type: duyaoss}]",
+		"bad url":      "sources: [{name: a, type: duyaoss, pages: {联通: 'duyaoss.com'}}]",
+		"no type":      "sources: [{name: a, pages: {联通: 'https://www.duyaoss.com/archives/3/'}}]",
+		"negative":     "ocr: {row_height: -1}",
+		"same name":    "sources:\n" + strings.Repeat("- {name: a, type: duyaoss, pages: {联通: 'https://a.com/'}}\n", 2),
+		"neg. workers": "workers: -1",
+		"bad cron":     "schedule: {cron: '0 3 * *'}",
+		"bad source":   "sources: [{name: a, type: duyaoss, schedule: daily, pages: {联通: 'https://a.com/'}}]",
+		"neg. jitter":  "schedule: {jitter: -1m}",
+		"log level":    "log: {level: verbose}",
+		"log format":   "log: {format: xml}",
+	}
+	for name, data := range cases {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Config with %s passed validation", name)
+		}
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	if _, err := Load("../../goduyaoss.example.yaml"); err != nil {
+		t.Errorf("Example config is invalid: %s", err)
+	}
+}
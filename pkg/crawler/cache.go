@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotModified is returned when an image hasn't changed since the last
+// time it was fetched, so there's no need to decode it or run OCR again.
+var ErrNotModified = errors.New("not modified since last fetch")
+
+// Cache stores the validators (ETag and Last-Modified) of previous responses
+// on disk, so that unchanged pages and images aren't downloaded again.
+// Page bodies are kept as well because they need to be parsed every time.
+type Cache struct {
+	dir string
+}
+
+// cacheEntry is saved as JSON next to the (optional) cached body.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	SHA256       string    `json:"sha256"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// NewCache creates the cache directory if it doesn't exist yet.
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+func (c *Cache) path(url string, ext string) string {
+	key := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(key[:])+ext)
+}
+
+// load returns the entry for url, or nil if it's not in the cache.
+func (c *Cache) load(url string) *cacheEntry {
+	data, err := ioutil.ReadFile(c.path(url, ".json"))
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil
+	}
+	return &entry
+}
+
+// loadBody returns the body saved along with the entry for url.
+func (c *Cache) loadBody(url string) ([]byte, error) {
+	return ioutil.ReadFile(c.path(url, ".body"))
+}
+
+// store saves the entry, and the body if it's not nil.
+func (c *Cache) store(entry *cacheEntry, body []byte) error {
+	if body != nil {
+		if err := ioutil.WriteFile(c.path(entry.URL, ".body"), body, 0644); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path(entry.URL, ".json"), data, 0644)
+}
+
+// remove deletes the entry and body of url, e.g. when they're out of sync.
+func (c *Cache) remove(url string) {
+	os.Remove(c.path(url, ".json"))
+	os.Remove(c.path(url, ".body"))
+}
+
+// setValidators adds the conditional request headers from a cached entry.
+func (entry *cacheEntry) setValidators(req *http.Request) {
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+}
+
+// newCacheEntry records the validators of a successful response.
+func newCacheEntry(url string, res *http.Response, body []byte) *cacheEntry {
+	sum := sha256.Sum256(body)
+	return &cacheEntry{
+		URL:          url,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		ContentType:  res.Header.Get("Content-Type"),
+		SHA256:       hex.EncodeToString(sum[:]),
+		FetchedAt:    time.Now(),
+	}
+}
@@ -1,104 +1,261 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"image"
-	"image/png"
-	"log"
+	_ "image/gif"  // GIF decoder
+	_ "image/jpeg" // JPEG decoder
+	_ "image/png"  // PNG decoder
+	"io/ioutil"
+	"net"
 	"net/http"
-	"regexp"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	log "github.com/sirupsen/logrus"
+	_ "golang.org/x/image/bmp"  // BMP decoder
+	_ "golang.org/x/image/webp" // WebP decoder
 )
 
-// Pages - the pages to crawl.
-var Pages = map[string]string{
-	"移动": "https://www.duyaoss.com/archives/1031/",
-	"联通": "https://www.duyaoss.com/archives/3/",
-	"电信": "https://www.duyaoss.com/archives/1/",
-}
-
 // Provider holds the information about a provider. It's possible for a provider
-// to have multiple subgroups with different names and speed test results (ImgURL).
+// to have multiple subgroups with different names and speed test results (Images).
 type Provider struct {
 	Name     string
-	ImgURL   string
+	Images   []Figure
 	Subgroup []Provider
 }
 
-// RequestPage - Given a URL, return the response as a goquery document.
-func RequestPage(url string) *goquery.Document {
-	res, err := http.Get(url)
+// Figure is one of the result images of a provider, e.g. one per test round.
+type Figure struct {
+	Index   int // position among the provider's images, starting from 0
+	URL     string
+	Alt     string
+	Caption string
+	Width   int // size hint from the URL fragment, 0 if unknown
+	Height  int
+}
+
+// Image is a downloaded image. The raw bytes are kept so they can be archived.
+type Image struct {
+	image.Image
+	URL    string
+	Data   []byte
+	Format string // format name from the `image` package, e.g. "png" or "jpeg"
+
+	entry *cacheEntry // saved by `Fetcher.CacheImage`
+}
+
+// Fetcher downloads pages and images. If `Cache` is set, conditional
+// requests are used to skip resources that haven't changed. Images with more
+// than `MaxPixels` pixels aren't decoded; zero means no limit.
+type Fetcher struct {
+	Client    *http.Client
+	Cache     *Cache
+	MaxPixels int
+}
+
+// DefaultFetcher is used by `RequestPage` and `FetchImage`. The images can be
+// several megabytes, so the timeout is generous.
+var DefaultFetcher = &Fetcher{
+	Client:    &http.Client{Timeout: 2 * time.Minute},
+	MaxPixels: DefaultMaxPixels,
+}
+
+// NewFetcher returns a `Fetcher` with the default settings and the given
+// cache, which may be nil.
+func NewFetcher(cache *Cache) *Fetcher {
+	return &Fetcher{
+		Client:    DefaultFetcher.Client,
+		Cache:     cache,
+		MaxPixels: DefaultFetcher.MaxPixels,
+	}
+}
+
+// fetch sends a GET request and reads the whole body. If cached is set, its
+// validators are sent and a 304 response returns `ErrNotModified`. The
+// returned entry should be stored once the body has been processed
+// successfully.
+func (f *Fetcher) fetch(ctx context.Context, url string, cached *cacheEntry) ([]byte, *cacheEntry, error) {
+	res, err := f.get(ctx, url, cached, false)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
+	}
+	if res.StatusCode == http.StatusNotModified && cached == nil {
+		// There was nothing to validate, so the cache of a proxy may be
+		// answering. Drop our entry and ask for the whole body again.
+		res.Body.Close()
+		if f.Cache != nil {
+			f.Cache.remove(url)
+		}
+		if res, err = f.get(ctx, url, nil, true); err != nil {
+			return nil, nil, err
+		}
 	}
 	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		log.Fatalf("status code error: %d %s", res.StatusCode, res.Status)
+
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		return nil, cached, ErrNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, &StatusError{URL: url, StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, bodyError(url, err)
+	}
+	entry := newCacheEntry(url, res, body)
+
+	// Some servers ignore the validators, so compare the content as well.
+	if cached != nil && cached.SHA256 == entry.SHA256 {
+		return body, entry, ErrNotModified
+	}
+	return body, entry, nil
+}
+
+// get sends a GET request with the validators of cached, if set. reload asks
+// caches on the way to fetch the resource from the server again.
+func (f *Fetcher) get(ctx context.Context, url string, cached *cacheEntry, reload bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		cached.setValidators(req)
+	}
+	if reload {
+		req.Header.Set("Cache-Control", "no-cache")
+	}
+
+	res, err := f.Client.Do(req)
+	if err != nil && isTimeout(err) {
+		return nil, &TimeoutError{URL: url, Err: err}
+	}
+	return res, err
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// bodyError wraps an error that occurred while reading a response body.
+func bodyError(url string, err error) error {
+	if isTimeout(err) {
+		return &TimeoutError{URL: url, Err: err}
+	}
+	return &DecodeError{URL: url, Err: err}
+}
+
+// RequestPage - Given a URL, return the response as a goquery document.
+// Unchanged pages are loaded from the cache.
+func (f *Fetcher) RequestPage(ctx context.Context, url string) (*goquery.Document, error) {
+	// Only send a conditional request if both the entry and the body of the
+	// cached page are still around
+	var cached *cacheEntry
+	var cachedBody []byte
+	if f.Cache != nil {
+		if body, err := f.Cache.loadBody(url); err == nil {
+			cached, cachedBody = f.Cache.load(url), body
+		}
+	}
+
+	body, entry, err := f.fetch(ctx, url, cached)
+	if err == ErrNotModified && body == nil {
+		body = cachedBody
+	} else if err != nil && err != ErrNotModified {
+		return nil, err
 	}
 
 	// Load the HTML document
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		log.Fatal(err)
-	}
-	return doc
-}
-
-// FetchProviders - Find the providers in the <h2> elements,
-// and parse the result into an array of `Provider` structs.
-func FetchProviders(doc *goquery.Document) []Provider {
-	var providers []Provider
-	regexProvider := regexp.MustCompile(`^\d*\.`)
-	doc.Find("h2").Each(func(i int, s *goquery.Selection) {
-		// Each provider's name starts with a serial number.
-		title := s.Text()
-		if regexProvider.MatchString(title) {
-			title = regexProvider.ReplaceAllString(title, "")
-			res := Provider{Name: title}
-
-			// See if there's subgroups. Check for <h3> elements until the next provider
-			s.NextFilteredUntil("h3", "h2").
-				Each(func(i int, ss *goquery.Selection) {
-					subTitle := ss.Text()
-
-					link, found := ss.NextFilteredUntil("figure", "h3").First().
-						Find("img").Attr("data-src")
-					if found {
-						subProvider := Provider{Name: subTitle, ImgURL: link}
-						res.Subgroup = append(res.Subgroup, subProvider)
-					}
-
-				})
-
-			// If there's no subgroups, find the image(s) for the provider
-			if res.Subgroup == nil {
-				link, found := s.NextFilteredUntil("figure", "h2").First().
-					Find("img").Attr("data-src")
-				if found {
-					res.ImgURL = link
-				}
-			}
-
-			providers = append(providers, res)
+		return nil, &DecodeError{URL: url, Err: err}
+	}
+	if f.Cache != nil {
+		if err := f.Cache.store(entry, body); err != nil {
+			log.Warnf("Error caching %s: %s", url, err)
 		}
-	})
-	return providers
+	}
+	return doc, nil
 }
 
-// FetchImage - Given the URL to an image, return an `image.Image` interface.
-func FetchImage(url string) image.Image {
-	res, err := http.Get(url)
+// FetchImage - Given the URL to an image, return the decoded `Image`.
+// Returns `ErrNotModified` if the image is unchanged since the last fetch
+// that was passed to `CacheImage`.
+// If the URL has a size hint (`#vwid=...&vhei=...`), images that are too
+// large are skipped before downloading, and images of the wrong size are
+// rejected as truncated.
+func (f *Fetcher) FetchImage(ctx context.Context, url string) (*Image, error) {
+	hintWidth, hintHeight := parseSizeHint(url)
+	if err := f.checkPixels(url, hintWidth, hintHeight); err != nil {
+		return nil, err
+	}
+
+	var cached *cacheEntry
+	if f.Cache != nil {
+		cached = f.Cache.load(url)
+	}
+	body, entry, err := f.fetch(ctx, url, cached)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		log.Fatalf("status code error: %d %s", res.StatusCode, res.Status)
+
+	// The decoder is picked by the magic bytes, so a wrong Content-Type
+	// header from the CDN doesn't matter.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err == image.ErrFormat {
+		err = fmt.Errorf("unsupported image format (Content-Type %q, detected %q)",
+			entry.ContentType, http.DetectContentType(body))
+	}
+	if err != nil {
+		return nil, &DecodeError{URL: url, Err: err}
+	}
+	if err := f.checkPixels(url, cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	if hintWidth > 0 && (cfg.Width != hintWidth || cfg.Height != hintHeight) {
+		return nil, &SizeError{
+			URL: url, Width: cfg.Width, Height: cfg.Height,
+			HintWidth: hintWidth, HintHeight: hintHeight,
+		}
 	}
 
-	img, err := png.Decode(res.Body)
+	img, format, err := image.Decode(bytes.NewReader(body))
 	if err != nil {
-		log.Fatalf("Error loading png image: %s", err)
+		return nil, &DecodeError{URL: url, Err: err}
+	}
+	return &Image{Image: img, URL: url, Data: body, Format: format, entry: entry}, nil
+}
+
+// CacheImage saves the validators of an image returned by `FetchImage`, so
+// that the next fetch is skipped if the image hasn't changed. It should only
+// be called once the results of the image are saved, or an image that failed
+// to be processed would never be fetched again.
+func (f *Fetcher) CacheImage(img *Image) error {
+	if f.Cache == nil || img.entry == nil {
+		return nil
+	}
+	return f.Cache.store(img.entry, nil)
+}
+
+// checkPixels makes sure an image of the given size fits in the memory budget.
+func (f *Fetcher) checkPixels(url string, width int, height int) error {
+	if f.MaxPixels > 0 && width*height > f.MaxPixels {
+		return &TooLargeError{URL: url, Width: width, Height: height, MaxPixels: f.MaxPixels}
 	}
-	return img
+	return nil
+}
+
+// RequestPage fetches a page using `DefaultFetcher`.
+func RequestPage(ctx context.Context, url string) (*goquery.Document, error) {
+	return DefaultFetcher.RequestPage(ctx, url)
+}
+
+// FetchImage fetches an image using `DefaultFetcher`.
+func FetchImage(ctx context.Context, url string) (*Image, error) {
+	return DefaultFetcher.FetchImage(ctx, url)
 }
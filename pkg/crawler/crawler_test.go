@@ -1,57 +1,213 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 var serverIndexResponse = []byte("pong")
 var sampleProvider = Provider{
-	Name:   "4.ssrcloud （CNIX 中转机场 高性价比）",
-	ImgURL: "https://user-images.githubusercontent.com/34016863/100780954-58ac3280-3445-11eb-928d-a75c71dcfe7f.png#vwid=1359&amp;vhei=8700",
+	Name: "4.ssrcloud （CNIX 中转机场 高性价比）",
+	Images: []Figure{
+		{URL: "https://user-images.githubusercontent.com/34016863/100780954-58ac3280-3445-11eb-928d-a75c71dcfe7f.png#vwid=1359&amp;vhei=8700"},
+	},
 }
 
-func TestFetchProviders(t *testing.T) {
+func TestFetchImage(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	img, err := FetchImage(context.Background(), sampleProvider.Images[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	width, height := img.Bounds().Max.X, img.Bounds().Max.Y
+	if width != 1359 {
+		t.Errorf("Width is %d and should be 1359", width)
+	}
+	if height != 8700 {
+		t.Errorf("Height is %d and should be 8700", width)
+	}
+
+}
+
+func TestFetchErrors(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	_, err := RequestPage(context.Background(), ts.URL+"/missing")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 404 {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	} else if !strings.HasSuffix(err.Error(), ": 404 Not Found") {
+		t.Errorf("Message of a 404 StatusError is %q", err)
+	}
+
+	_, err = FetchImage(context.Background(), ts.URL)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Errorf("Expected a DecodeError, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = FetchImage(ctx, ts.URL+"/png"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	doc, err := RequestPage(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDuyaoss().ParseProviders(doc); err != ErrNoProviders {
+		t.Errorf("Expected ErrNoProviders, got %v", err)
+	}
+}
+
+func TestFetchImageFormats(t *testing.T) {
 	ts := newTestServer()
 	defer ts.Close()
 
-	doc := RequestPage(ts.URL + "/html")
-	providers := FetchProviders(doc)
-	if len(providers) != 51 {
-		t.Errorf("Found %d out of 51 providers.", len(providers))
+	for _, format := range []string{"png", "jpeg"} {
+		img, err := FetchImage(context.Background(), ts.URL+"/"+format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Format != format {
+			t.Errorf("Format of /%s detected as %q", format, img.Format)
+		}
+		if width := img.Bounds().Max.X; width != 20 {
+			t.Errorf("Width of /%s is %d and should be 20", format, width)
+		}
 	}
+}
 
-	subgroupCount := 0
-	for _, provider := range providers {
-		if provider.Subgroup != nil {
-			subgroupCount++
+func TestParseSizeHint(t *testing.T) {
+	cases := []struct {
+		link          string
+		width, height int
+	}{
+		{sampleProvider.Images[0].URL, 1359, 8700},
+		{"https://example.com/a.png#vwid=910&vhei=3000", 910, 3000},
+		{"https://example.com/a.png", 0, 0},
+		{"https://example.com/a.png#vwid=910", 0, 0},
+		{"https://example.com/a.png#top", 0, 0},
+	}
+	for _, c := range cases {
+		width, height := parseSizeHint(c.link)
+		if width != c.width || height != c.height {
+			t.Errorf("Size hint of %q is %dx%d, should be %dx%d",
+				c.link, width, height, c.width, c.height)
 		}
 	}
-	if subgroupCount != 7 {
-		t.Errorf("Found %d out of 7 subgroups.", subgroupCount)
+
+	if (Figure{Width: 551, Height: 90}).LooksLikeTable() {
+		t.Errorf("551x90 banner looks like a table")
+	}
+	if !(Figure{Width: 1359, Height: 8700}).LooksLikeTable() {
+		t.Errorf("1359x8700 image doesn't look like a table")
 	}
 }
 
-func TestFetchImage(t *testing.T) {
+func TestFetchImageSizeChecks(t *testing.T) {
 	ts := newTestServer()
 	defer ts.Close()
 
-	img := FetchImage(sampleProvider.ImgURL)
+	if _, err := FetchImage(context.Background(), ts.URL+"/png#vwid=20&vhei=10"); err != nil {
+		t.Errorf("Image matching its size hint failed with %v", err)
+	}
 
-	width, height := img.Bounds().Max.X, img.Bounds().Max.Y
-	if width != 1359 {
-		t.Errorf("Width is %d and should be 1359", width)
+	_, err := FetchImage(context.Background(), ts.URL+"/png#vwid=20&vhei=30")
+	var sizeErr *SizeError
+	if !errors.As(err, &sizeErr) {
+		t.Errorf("Expected a SizeError, got %v", err)
 	}
-	if height != 8700 {
-		t.Errorf("Height is %d and should be 8700", width)
+
+	f := &Fetcher{Client: DefaultFetcher.Client, MaxPixels: 100}
+	for _, url := range []string{ts.URL + "/png", ts.URL + "/png#vwid=20&vhei=10"} {
+		_, err = f.FetchImage(context.Background(), url)
+		var tooLargeErr *TooLargeError
+		if !errors.As(err, &tooLargeErr) {
+			t.Errorf("Expected a TooLargeError for %s, got %v", url, err)
+		}
+	}
+}
+
+func TestFetcherCache(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "goduyaoss-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFetcher(cache)
+
+	// Images are only cached once they're processed
+	if _, err := f.FetchImage(context.Background(), ts.URL+"/png"); err != nil {
+		t.Fatal(err)
+	}
+	img, err := f.FetchImage(context.Background(), ts.URL+"/png")
+	if err != nil {
+		t.Fatalf("Second fetch before caching returned %v", err)
+	}
+	if err := f.CacheImage(img); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.FetchImage(context.Background(), ts.URL+"/png"); err != ErrNotModified {
+		t.Errorf("Expected ErrNotModified after caching, got %v", err)
 	}
 
+	// Unchanged pages are still parsed from the cached body
+	for i := 0; i < 2; i++ {
+		doc, err := f.RequestPage(context.Background(), ts.URL+"/html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Find("h2").Length() == 0 {
+			t.Errorf("Request %d: no <h2> elements in page", i+1)
+		}
+	}
+
+	// A 304 without validators is asked again, e.g. when only the body of
+	// a page is left in the cache
+	if err := ioutil.WriteFile(cache.path(ts.URL+"/proxied", ".body"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := f.RequestPage(context.Background(), ts.URL+"/proxied")
+	if err != nil {
+		t.Fatalf("Unexpected 304 returned %v", err)
+	}
+	if doc.Find("h2").Length() == 0 {
+		t.Error("Page of an unexpected 304 has no <h2> elements")
+	}
 }
 
 func newTestServer() *httptest.Server {
 	htmlPage, _ := ioutil.ReadFile("testdata/duyaoss3.html")
+	modTime := time.Date(2020, 12, 12, 0, 0, 0, 0, time.UTC)
+
+	sampleImage := image.NewGray(image.Rect(0, 0, 20, 10))
+	pngImage, jpegImage := new(bytes.Buffer), new(bytes.Buffer)
+	png.Encode(pngImage, sampleImage)
+	jpeg.Encode(jpegImage, sampleImage, nil)
 
 	mux := http.NewServeMux()
 
@@ -60,10 +216,35 @@ func newTestServer() *httptest.Server {
 		w.Write(serverIndexResponse)
 	})
 
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
 	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		http.ServeContent(w, r, "duyaoss3.html", modTime, bytes.NewReader(htmlPage))
+	})
+
+	mux.HandleFunc("/png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"sample"`)
+		http.ServeContent(w, r, "sample.png", time.Time{}, bytes.NewReader(pngImage.Bytes()))
+	})
+
+	mux.HandleFunc("/proxied", func(w http.ResponseWriter, r *http.Request) {
+		// A proxy that answers 304 unless asked to reload
+		if r.Header.Get("Cache-Control") != "no-cache" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		w.Header().Set("Content-Type", "text/html")
 		w.Write(htmlPage)
 	})
 
+	mux.HandleFunc("/jpeg", func(w http.ResponseWriter, r *http.Request) {
+		// Some CDNs send the wrong content type
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(jpegImage.Bytes())
+	})
+
 	return httptest.NewServer(mux)
 }
@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DuyaossPages - the pages to crawl on www.duyaoss.com.
+var DuyaossPages = map[string]string{
+	"移动": "https://www.duyaoss.com/archives/1031/",
+	"联通": "https://www.duyaoss.com/archives/3/",
+	"电信": "https://www.duyaoss.com/archives/1/",
+}
+
+// Duyaoss is the `Source` for www.duyaoss.com and its mirrors, which share
+// the same WordPress theme.
+type Duyaoss struct {
+	name  string
+	pages map[string]string
+}
+
+// NewDuyaoss returns a `Duyaoss` source that crawls `DuyaossPages`.
+func NewDuyaoss() *Duyaoss {
+	return &Duyaoss{name: "duyaoss", pages: DuyaossPages}
+}
+
+// Name returns the name of the source.
+func (d *Duyaoss) Name() string {
+	return d.name
+}
+
+// Pages returns the pages to crawl.
+func (d *Duyaoss) Pages() map[string]string {
+	return d.pages
+}
+
+// ParseProviders - Find the providers in the <h2> elements,
+// and parse the result into an array of `Provider` structs.
+func (d *Duyaoss) ParseProviders(doc *goquery.Document) ([]Provider, error) {
+	var providers []Provider
+	regexProvider := regexp.MustCompile(`^\d*\.`)
+	doc.Find("h2").Each(func(i int, s *goquery.Selection) {
+		// Each provider's name starts with a serial number.
+		title := s.Text()
+		if regexProvider.MatchString(title) {
+			title = regexProvider.ReplaceAllString(title, "")
+			res := Provider{Name: title}
+
+			// See if there's subgroups. Check for <h3> elements until the next provider
+			s.NextFilteredUntil("h3", "h2").
+				Each(func(i int, ss *goquery.Selection) {
+					subProvider := Provider{
+						Name:   ss.Text(),
+						Images: parseFigures(ss.NextFilteredUntil("figure", "h2, h3")),
+					}
+					if subProvider.Images != nil {
+						res.Subgroup = append(res.Subgroup, subProvider)
+					}
+				})
+
+			// If there's no subgroups, find the image(s) for the provider
+			if res.Subgroup == nil {
+				res.Images = parseFigures(s.NextFilteredUntil("figure", "h2"))
+			}
+
+			providers = append(providers, res)
+		}
+	})
+
+	if len(providers) == 0 {
+		return nil, ErrNoProviders
+	}
+	return providers, nil
+}
+
+// parseFigures finds the lazy-loaded images in the <figure> elements.
+func parseFigures(figures *goquery.Selection) []Figure {
+	var res []Figure
+	figures.Each(func(i int, s *goquery.Selection) {
+		img := s.Find("img").First()
+		link, found := img.Attr("data-src")
+		if !found {
+			return
+		}
+
+		caption := strings.TrimSpace(s.Find("figcaption").Text())
+		if caption == "" {
+			caption, _ = s.Find("a").Attr("data-caption")
+		}
+		alt, _ := img.Attr("alt")
+		width, height := parseSizeHint(link)
+
+		res = append(res, Figure{
+			Index:   len(res),
+			URL:     link,
+			Alt:     alt,
+			Caption: caption,
+			Width:   width,
+			Height:  height,
+		})
+	})
+	return res
+}
+
+// ImageURL resolves links relative to the page. The images are usually
+// hosted on GitHub, so most links are already absolute.
+func (d *Duyaoss) ImageURL(pageURL string, link string) (string, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,118 @@
+package crawler
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestDuyaossParseProviders(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	doc, err := RequestPage(context.Background(), ts.URL+"/html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers, err := NewDuyaoss().ParseProviders(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 51 {
+		t.Errorf("Found %d out of 51 providers.", len(providers))
+	}
+
+	subgroupCount, imageCount, hintCount := 0, 0, 0
+	for _, provider := range providers {
+		if provider.Subgroup != nil {
+			subgroupCount++
+		}
+		for _, p := range append([]Provider{provider}, provider.Subgroup...) {
+			for i, fig := range p.Images {
+				if fig.Index != i {
+					t.Errorf("Image %d of %q has index %d", i, p.Name, fig.Index)
+				}
+				imageCount++
+				if fig.Width > 0 && fig.Height > 0 {
+					hintCount++
+				}
+			}
+		}
+	}
+	if subgroupCount != 7 {
+		t.Errorf("Found %d out of 7 subgroups.", subgroupCount)
+	}
+	if imageCount != 58 {
+		t.Errorf("Found %d out of 58 images.", imageCount)
+	}
+	if hintCount != imageCount {
+		t.Errorf("Found size hints for %d out of %d images.", hintCount, imageCount)
+	}
+}
+
+func TestDuyaossMultipleFigures(t *testing.T) {
+	page := `<h2>1.Provider</h2>
+<figure><a data-caption="round 1"><img alt="first" data-src="https://example.com/1.png"></a></figure>
+<p>Second round</p>
+<figure><a><img alt="second" data-src="https://example.com/2.png"></a><figcaption>round 2</figcaption></figure>
+<h2>2.Other</h2>
+<figure><a><img data-src="https://example.com/3.png"></a></figure>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers, err := NewDuyaoss().ParseProviders(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Figure{
+		{Index: 0, URL: "https://example.com/1.png", Alt: "first", Caption: "round 1"},
+		{Index: 1, URL: "https://example.com/2.png", Alt: "second", Caption: "round 2"},
+	}
+	if !reflect.DeepEqual(providers[0].Images, want) {
+		t.Errorf("Images are %+v, should be %+v", providers[0].Images, want)
+	}
+	if len(providers[1].Images) != 1 {
+		t.Errorf("Found %d images for the second provider, should be 1", len(providers[1].Images))
+	}
+}
+
+func TestDuyaossImageURL(t *testing.T) {
+	d := NewDuyaoss()
+	page := "https://www.duyaoss.com/archives/3/"
+
+	link, err := d.ImageURL(page, sampleProvider.Images[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != sampleProvider.Images[0].URL {
+		t.Errorf("Absolute link changed to %q", link)
+	}
+
+	link, err = d.ImageURL(page, "/usr/uploads/result.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "https://www.duyaoss.com/usr/uploads/result.png" {
+		t.Errorf("Relative link resolved to %q", link)
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	pages := map[string]string{"联通": "https://mirror.example.com/archives/3/"}
+	src, err := NewSource("duyaoss", "mirror", pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Name() != "mirror" || src.Pages()["联通"] != pages["联通"] {
+		t.Errorf("Source is %+v", src)
+	}
+
+	if _, err := NewSource("wordpress", "blog", pages); err == nil {
+		t.Errorf("Unknown source type didn't return an error")
+	}
+}
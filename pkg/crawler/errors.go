@@ -0,0 +1,72 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoProviders is returned when a page doesn't contain any provider sections.
+var ErrNoProviders = errors.New("no providers found on page")
+
+// StatusError is returned when the server responds with a non-200 status code.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string // e.g. "404 Not Found"
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code error for %s: %s", e.URL, e.Status)
+}
+
+// TimeoutError is returned when a request doesn't finish within `Client.Timeout`.
+type TimeoutError struct {
+	URL string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout fetching %s: %s", e.URL, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// DecodeError is returned when the response body can't be parsed.
+type DecodeError struct {
+	URL string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding %s: %s", e.URL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// SizeError is returned when the size of an image doesn't match the hint in
+// its URL, which usually means the download was truncated.
+type SizeError struct {
+	URL        string
+	Width      int
+	Height     int
+	HintWidth  int
+	HintHeight int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("image %s is %dx%d, expected %dx%d",
+		e.URL, e.Width, e.Height, e.HintWidth, e.HintHeight)
+}
+
+// TooLargeError is returned for images with more pixels than `Fetcher.MaxPixels`.
+type TooLargeError struct {
+	URL       string
+	Width     int
+	Height    int
+	MaxPixels int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("image %s is %dx%d, larger than the limit of %d pixels",
+		e.URL, e.Width, e.Height, e.MaxPixels)
+}
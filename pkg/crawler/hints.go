@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Result tables have at least two header rows, one node and two footer rows
+// of 30px each, and the columns need some room. Images smaller than this are
+// banners or logos.
+var (
+	MinTableWidth  = 500
+	MinTableHeight = 150
+)
+
+// DefaultMaxPixels is the memory budget of `DefaultFetcher`. The largest
+// images seen so far are around 1400x9000 pixels.
+const DefaultMaxPixels = 40000000
+
+// parseSizeHint reads the image size from URL fragments such as
+// `#vwid=1359&vhei=8700`. Zeros are returned if there's no such fragment.
+func parseSizeHint(link string) (int, int) {
+	idx := strings.Index(link, "#")
+	if idx < 0 {
+		return 0, 0
+	}
+	// Links copied from the raw HTML may still have escaped ampersands
+	fragment := strings.ReplaceAll(link[idx+1:], "&amp;", "&")
+	values, err := url.ParseQuery(fragment)
+	if err != nil {
+		return 0, 0
+	}
+
+	width, errWidth := strconv.Atoi(values.Get("vwid"))
+	height, errHeight := strconv.Atoi(values.Get("vhei"))
+	if errWidth != nil || errHeight != nil || width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	return width, height
+}
+
+// LooksLikeTable tells if the size hint of the figure is large enough for a
+// result table. Figures without a size hint are assumed to be tables.
+func (fig Figure) LooksLikeTable() bool {
+	if fig.Width == 0 || fig.Height == 0 {
+		return true
+	}
+	return fig.Width >= MinTableWidth && fig.Height >= MinTableHeight
+}
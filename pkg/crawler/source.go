@@ -0,0 +1,40 @@
+package crawler
+
+import (
+	"fmt"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Source is a website that publishes SSRSpeed results. Each source knows
+// which pages to crawl and how to find the providers on them.
+type Source interface {
+	// Name identifies the source, e.g. in logs.
+	Name() string
+	// Pages maps the net providers (移动/联通/电信) to the page with their results.
+	Pages() map[string]string
+	// ParseProviders finds the providers and their images on a page.
+	ParseProviders(doc *goquery.Document) ([]Provider, error)
+	// ImageURL resolves an image link found on pageURL to an absolute URL.
+	ImageURL(pageURL string, link string) (string, error)
+}
+
+// Sources - the sources to crawl if none are configured.
+var Sources = []Source{NewDuyaoss()}
+
+// sourceTypes are the parsers that can be used for configured sources.
+var sourceTypes = map[string]func(name string, pages map[string]string) Source{
+	"duyaoss": func(name string, pages map[string]string) Source {
+		return &Duyaoss{name: name, pages: pages}
+	},
+}
+
+// NewSource creates a source of the given type that crawls pages. It's used
+// to add mirrors or other sites from the config file.
+func NewSource(sourceType string, name string, pages map[string]string) (Source, error) {
+	newSource, ok := sourceTypes[sourceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown source type %q", sourceType)
+	}
+	return newSource(name, pages), nil
+}
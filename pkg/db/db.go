@@ -1,173 +1,390 @@
 package db
 
 import (
-	"database/sql"
-	"log"
-	"regexp"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/parse"
 )
 
+// schema is the first version of the SQLite schema, see migrations. See
+// pgSchema for PostgreSQL.
+//
+// A run is a crawl or reprocess command. Each result image of a provider
+// has one row in images per timestamp, and the nodes in its table are
+// linked to it by measurements. Nodes are identified by their remarks
+// within a provider, so the history of a node is a join on its id.
 var schema = `
-CREATE TABLE IF NOT EXISTS duyaoss (
-	net_provider    TEXT,
-	provider        TEXT,
-	timestamp       DATE,
-	provider_group  TEXT,
-	remarks         TEXT,
-	loss            REAL,
-	ping            REAL,
-	google_ping     REAL,
-	avg_speed       REAL,
-	max_speed       REAL  DEFAULT 0,
-	udp_nat_type    TEXT  DEFAULT ''
+CREATE TABLE IF NOT EXISTS runs (
+	id           INTEGER   PRIMARY KEY,
+	command      TEXT      NOT NULL,
+	started_at   DATETIME  NOT NULL,
+	finished_at  DATETIME
 );
+CREATE TABLE IF NOT EXISTS providers (
+	id            INTEGER  PRIMARY KEY,
+	net_provider  TEXT     NOT NULL,
+	name          TEXT     NOT NULL,
+	UNIQUE (net_provider, name)
+);
+CREATE TABLE IF NOT EXISTS images (
+	id           INTEGER   PRIMARY KEY,
+	provider_id  INTEGER   NOT NULL REFERENCES providers (id),
+	run_id       INTEGER   REFERENCES runs (id),
+	image_index  INTEGER   NOT NULL DEFAULT 0,
+	timestamp    DATETIME  NOT NULL,
+	url          TEXT      NOT NULL DEFAULT '',
+	sha256       TEXT      NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS images_provider_idx
+	ON images (provider_id, image_index, timestamp);
+CREATE TABLE IF NOT EXISTS nodes (
+	id              INTEGER  PRIMARY KEY,
+	provider_id     INTEGER  NOT NULL REFERENCES providers (id),
+	remarks         TEXT     NOT NULL,
+	provider_group  TEXT     NOT NULL DEFAULT '',
+	UNIQUE (provider_id, remarks)
+);
+CREATE TABLE IF NOT EXISTS measurements (
+	id            INTEGER  PRIMARY KEY,
+	image_id      INTEGER  NOT NULL REFERENCES images (id),
+	node_id       INTEGER  NOT NULL REFERENCES nodes (id),
+	position      INTEGER  NOT NULL,
+	loss          REAL,
+	ping          REAL,
+	google_ping   REAL,
+	avg_speed     REAL,
+	max_speed     REAL     NOT NULL DEFAULT 0,
+	udp_nat_type  TEXT     NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS measurements_image_idx ON measurements (image_id);
+CREATE INDEX IF NOT EXISTS measurements_node_idx ON measurements (node_id);
 `
 
-var insertSQL = `
-INSERT INTO duyaoss (
-	net_provider, provider, timestamp, provider_group, remarks,
-	loss, ping, google_ping, avg_speed, max_speed, udp_nat_type
+// columns select the fields of Row from the joined tables m, i, p and n.
+var columns = `
+	p.net_provider, p.name AS provider, i.image_index, i.timestamp, i.sha256,
+	n.provider_group, n.remarks, m.loss, m.ping, m.google_ping,
+	m.avg_speed, m.max_speed, m.udp_nat_type,
+	m.loss_status, m.ping_status, m.google_ping_status,
+	m.avg_speed_status, m.max_speed_status,
+	m.loss_text, m.ping_text, m.google_ping_text,
+	m.avg_speed_text, m.max_speed_text`
+
+// joins link the measurements to their image, provider and node.
+var joins = `
+FROM measurements m
+JOIN images i ON i.id = m.image_id
+JOIN providers p ON p.id = i.provider_id
+JOIN nodes n ON n.id = m.node_id`
+
+var insertRunSQL = `INSERT INTO runs (command, started_at) VALUES (?, ?)`
+
+var finishRunSQL = `UPDATE runs SET finished_at =? WHERE id =?;`
+
+var selectProviderSQL = `SELECT id FROM providers WHERE net_provider =? AND name =?;`
+
+var upsertProviderSQL = `
+INSERT INTO providers (net_provider, name) VALUES (?, ?)
+ON CONFLICT (net_provider, name) DO NOTHING;
+`
+
+var selectImageSQL = `
+SELECT id FROM images
+WHERE
+	provider_id =? AND image_index =? AND timestamp =?;
+`
+
+// addImageSQL adds an image unless it was saved before, so that inserting
+// the same image twice is a no-op.
+var addImageSQL = `
+INSERT INTO images (provider_id, run_id, image_index, timestamp, url, sha256)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (provider_id, image_index, timestamp) DO NOTHING;
+`
+
+var upsertImageSQL = `
+INSERT INTO images (provider_id, run_id, image_index, timestamp, url, sha256)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (provider_id, image_index, timestamp) DO UPDATE SET
+	run_id = excluded.run_id, url = excluded.url, sha256 = excluded.sha256;
+`
+
+var selectNodeIDSQL = `SELECT id FROM nodes WHERE provider_id =? AND remarks =?;`
+
+var upsertNodeSQL = `
+INSERT INTO nodes (provider_id, remarks, provider_group) VALUES (?, ?, ?)
+ON CONFLICT (provider_id, remarks) DO UPDATE SET
+	provider_group = excluded.provider_group
+WHERE nodes.provider_group <> excluded.provider_group;
+`
+
+// upsertMeasurementSQL keeps the last row of a node that appears more than
+// once in a table.
+var upsertMeasurementSQL = `
+INSERT INTO measurements (
+	image_id, node_id, position,
+	loss, ping, google_ping, avg_speed, max_speed, udp_nat_type,
+	loss_status, ping_status, google_ping_status, avg_speed_status, max_speed_status,
+	loss_text, ping_text, google_ping_text, avg_speed_text, max_speed_text
 )
-VALUES (
-	:net_provider, :provider, :timestamp, :provider_group, :remarks,
-	:loss, :ping, :google_ping, :avg_speed, :max_speed, :udp_nat_type
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (image_id, node_id) DO UPDATE SET
+	position = excluded.position, loss = excluded.loss, ping = excluded.ping,
+	google_ping = excluded.google_ping, avg_speed = excluded.avg_speed,
+	max_speed = excluded.max_speed, udp_nat_type = excluded.udp_nat_type,
+	loss_status = excluded.loss_status, ping_status = excluded.ping_status,
+	google_ping_status = excluded.google_ping_status,
+	avg_speed_status = excluded.avg_speed_status,
+	max_speed_status = excluded.max_speed_status,
+	loss_text = excluded.loss_text, ping_text = excluded.ping_text,
+	google_ping_text = excluded.google_ping_text,
+	avg_speed_text = excluded.avg_speed_text,
+	max_speed_text = excluded.max_speed_text;
+`
+
+var deleteMeasurementsSQL = `DELETE FROM measurements WHERE image_id =?;`
+
+// uniqueKeysSQL removes the duplicates that could be inserted before the
+// unique keys existed: the first image of a provider at a timestamp is
+// kept, with the last measurement of each node.
+var uniqueKeysSQL = `
+DELETE FROM measurements WHERE image_id NOT IN (
+	SELECT MIN(id) FROM images GROUP BY provider_id, image_index, timestamp
+);
+DELETE FROM images WHERE id NOT IN (
+	SELECT MIN(id) FROM images GROUP BY provider_id, image_index, timestamp
 );
+DELETE FROM measurements WHERE id NOT IN (
+	SELECT MAX(id) FROM measurements GROUP BY image_id, node_id
+);
+DROP INDEX IF EXISTS images_provider_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS images_key ON images (provider_id, image_index, timestamp);
+DROP INDEX IF EXISTS measurements_image_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS measurements_key ON measurements (image_id, node_id);
+`
+
+// nullableSQL lets the numbers of a measurement be NULL, and adds the status
+// and the OCR text of each of them. SQLite can't drop NOT NULL from a
+// column, so the table is copied.
+var nullableSQL = `
+CREATE TABLE measurements_new (
+	id                  INTEGER  PRIMARY KEY,
+	image_id            INTEGER  NOT NULL REFERENCES images (id),
+	node_id             INTEGER  NOT NULL REFERENCES nodes (id),
+	position            INTEGER  NOT NULL,
+	loss                REAL,
+	ping                REAL,
+	google_ping         REAL,
+	avg_speed           REAL,
+	max_speed           REAL,
+	udp_nat_type        TEXT  NOT NULL DEFAULT '',
+	loss_status         TEXT  NOT NULL DEFAULT 'ok',
+	ping_status         TEXT  NOT NULL DEFAULT 'ok',
+	google_ping_status  TEXT  NOT NULL DEFAULT 'ok',
+	avg_speed_status    TEXT  NOT NULL DEFAULT 'ok',
+	max_speed_status    TEXT  NOT NULL DEFAULT 'ok',
+	loss_text           TEXT  NOT NULL DEFAULT '',
+	ping_text           TEXT  NOT NULL DEFAULT '',
+	google_ping_text    TEXT  NOT NULL DEFAULT '',
+	avg_speed_text      TEXT  NOT NULL DEFAULT '',
+	max_speed_text      TEXT  NOT NULL DEFAULT ''
+);
+INSERT INTO measurements_new (
+	id, image_id, node_id, position,
+	loss, ping, google_ping, avg_speed, max_speed, udp_nat_type
+)
+SELECT
+	id, image_id, node_id, position,
+	loss, ping, google_ping, avg_speed, max_speed, udp_nat_type
+FROM measurements;
+DROP TABLE measurements;
+ALTER TABLE measurements_new RENAME TO measurements;
+CREATE UNIQUE INDEX measurements_key ON measurements (image_id, node_id);
+CREATE INDEX measurements_node_idx ON measurements (node_id);
+`
+
+// zeroValuesSQL clears the zeros that were saved before the status was
+// added, for cells like N/A and for the MaxSpeed of tables without that
+// column. Their text wasn't kept, so a zero ping or speed can't be told
+// apart from a missing one. A loss of 0 is a real value and is kept.
+var zeroValuesSQL = `
+UPDATE measurements SET ping = NULL, ping_status = 'not_tested'
+WHERE ping = 0 AND ping_text = '';
+UPDATE measurements SET google_ping = NULL, google_ping_status = 'not_tested'
+WHERE google_ping = 0 AND google_ping_text = '';
+UPDATE measurements SET avg_speed = NULL, avg_speed_status = 'not_tested'
+WHERE avg_speed = 0 AND avg_speed_text = '';
+UPDATE measurements SET max_speed = NULL, max_speed_status = 'not_tested'
+WHERE max_speed = 0 AND max_speed_text = '';
 `
 
 var querySQL = `
-SELECT timestamp FROM duyaoss
+SELECT i.timestamp FROM images i
+JOIN providers p ON p.id = i.provider_id
 WHERE
-	net_provider =? AND provider =?
+	p.net_provider =? AND p.name =? AND i.image_index =?
 ORDER BY
-	timestamp DESC LIMIT 1;
+	i.timestamp DESC LIMIT 1;
 `
 
 // Row is the struct for a row to be inserted in the database
 type Row struct {
-	NetProvider string    `db:"net_provider"`
-	Provider    string    `db:"provider"`
-	Timestamp   time.Time `db:"timestamp"`
-	Group       string    `db:"provider_group"`
-	Remarks     string    `db:"remarks"`
-	Loss        float64   `db:"loss"`
-	Ping        float64   `db:"ping"`
-	GooglePing  float64   `db:"google_ping"`
-	AvgSpeed    float64   `db:"avg_speed"`
-	MaxSpeed    float64   `db:"max_speed"`
-	UDPNATType  string    `db:"udp_nat_type"`
+	NetProvider string    `db:"net_provider" json:"net_provider"`
+	Provider    string    `db:"provider" json:"provider"`
+	ImageIndex  int       `db:"image_index" json:"image_index"`
+	Timestamp   time.Time `db:"timestamp" json:"timestamp"`
+	SHA256      string    `db:"sha256" json:"sha256"` // hash of the image in the archive, if it was saved
+	Group       string    `db:"provider_group" json:"group"`
+	Remarks     string    `db:"remarks" json:"remarks"`
+	Loss        *float64  `db:"loss" json:"loss"`
+	Ping        *float64  `db:"ping" json:"ping"`
+	GooglePing  *float64  `db:"google_ping" json:"google_ping"`
+	AvgSpeed    *float64  `db:"avg_speed" json:"avg_speed"`
+	MaxSpeed    *float64  `db:"max_speed" json:"max_speed"`
+	UDPNATType  string    `db:"udp_nat_type" json:"udp_nat_type"`
+
+	// The status of each number, see StatusOK. Numbers that aren't OK or
+	// uncertain are nil.
+	LossStatus       string `db:"loss_status" json:"loss_status"`
+	PingStatus       string `db:"ping_status" json:"ping_status"`
+	GooglePingStatus string `db:"google_ping_status" json:"google_ping_status"`
+	AvgSpeedStatus   string `db:"avg_speed_status" json:"avg_speed_status"`
+	MaxSpeedStatus   string `db:"max_speed_status" json:"max_speed_status"`
+
+	// The text of each number as read by OCR. It's empty for rows saved
+	// before the text was kept.
+	LossText       string `db:"loss_text" json:"loss_text"`
+	PingText       string `db:"ping_text" json:"ping_text"`
+	GooglePingText string `db:"google_ping_text" json:"google_ping_text"`
+	AvgSpeedText   string `db:"avg_speed_text" json:"avg_speed_text"`
+	MaxSpeedText   string `db:"max_speed_text" json:"max_speed_text"`
 }
 
-// connectDb connects to a database, verifies with a ping, and creates the table.
-func connectDb(dbFilename string) *sqlx.DB {
-	db, err := sqlx.Connect("sqlite3", dbFilename)
+// Status of a number read from a table.
+const (
+	StatusOK          = "ok"
+	StatusNotTested   = "not_tested"  // the node wasn't tested, or the table has no such column
+	StatusUnparseable = "unparseable" // the text isn't a number, e.g. because OCR misread it
+	StatusUncertain   = "uncertain"   // the number was read with fixes, e.g. a guessed decimal point
+)
+
+// mustOpen opens a store for the helpers below, which exit on errors.
+func mustOpen(dbName string) Store {
+	store, err := Open(dbName)
 	if err != nil {
-		log.Fatalln(err)
+		log.Fatalf("Error opening %s: %s", dbName, err)
 	}
-	db.MustExec(schema)
+	return store
+}
+
+// InsertRows adds rows to db in the correct format. The image index tells
+// apart multiple result images of the same provider. The database is opened
+// for this call only, long-running callers should keep a Store instead.
+func InsertRows(dbName string, netProvider string, provider string, imageIndex int, timestamp time.Time, tbl [][]string) {
+	store := mustOpen(dbName)
+	defer store.Close()
 
-	return db
+	err := store.InsertSnapshot(Snapshot{NetProvider: netProvider, Provider: provider, ImageIndex: imageIndex, Timestamp: timestamp, Table: tbl})
+	if err != nil && err != ErrExists {
+		log.Fatalf("Error saving results for %s -> %s: %s", netProvider, provider, err)
+	}
 }
 
-// InsertRows adds rows to db in the correct format
-func InsertRows(dbName string, netProvider string, provider string, timestamp time.Time, tbl [][]string) {
-	DB := connectDb(dbName)
-	defer DB.Close()
-	numRows, numCols := len(tbl[0]), len(tbl)
+// ReplaceRows removes the rows of a provider at the given timestamp and adds
+// the new ones in a single transaction. It's used to correct history when
+// images are processed again.
+func ReplaceRows(dbName string, netProvider string, provider string, imageIndex int, timestamp time.Time, tbl [][]string) {
+	store := mustOpen(dbName)
+	defer store.Close()
+
+	err := store.ReplaceSnapshot(Snapshot{NetProvider: netProvider, Provider: provider, ImageIndex: imageIndex, Timestamp: timestamp, Table: tbl})
+	if err != nil {
+		log.Fatalf("Error replacing results for %s -> %s: %s", netProvider, provider, err)
+	}
+}
 
-	tx := DB.MustBegin()
+// tableRows converts the columns read by OCR to rows.
+func tableRows(s Snapshot) []Row {
+	tbl := s.Table
+	numRows, numCols := len(tbl[0]), len(tbl)
+	rows := make([]Row, numRows)
 	for i := 0; i < numRows; i++ {
 		rowData := Row{
-			NetProvider: netProvider,
-			Provider:    provider,
-			Timestamp:   timestamp,
+			NetProvider: s.NetProvider,
+			Provider:    s.Provider,
+			ImageIndex:  s.ImageIndex,
+			Timestamp:   s.Timestamp,
 			Group:       tbl[0][i],
 			Remarks:     tbl[1][i],
-			Loss:        fixPercent(tbl[2][i]),
-			Ping:        fixNumber(tbl[3][i]),
-			GooglePing:  fixNumber(tbl[4][i]),
-			AvgSpeed:    fixSpeed(tbl[5][i]),
+
+			LossText:       tbl[2][i],
+			PingText:       tbl[3][i],
+			GooglePingText: tbl[4][i],
+			AvgSpeedText:   tbl[5][i],
+			// tables without the column are like nodes that weren't tested
+			MaxSpeedStatus: StatusNotTested,
 		}
+		rowData.Loss, rowData.LossStatus = parseCell(tbl[2][i], parse.Loss)
+		rowData.Ping, rowData.PingStatus = parseCell(tbl[3][i], parse.Ping)
+		rowData.GooglePing, rowData.GooglePingStatus = parseCell(tbl[4][i], parse.Ping)
+		rowData.AvgSpeed, rowData.AvgSpeedStatus = parseCell(tbl[5][i], parse.Speed)
 
 		if numCols == 7 {
 			rowData.UDPNATType = tbl[6][i]
 		}
 		if numCols == 8 {
-			rowData.MaxSpeed = fixSpeed(tbl[6][i])
+			rowData.MaxSpeedText = tbl[6][i]
+			rowData.MaxSpeed, rowData.MaxSpeedStatus = parseCell(tbl[6][i], parse.Speed)
 			rowData.UDPNATType = tbl[7][i]
 		}
-
-		_, err := tx.NamedExec(insertSQL, &rowData)
-		if err != nil {
-			log.Fatalf("Error in transaction for %s -> %s, row %d\n",
-				netProvider, provider, i)
-		}
+		rows[i] = rowData
 	}
-	tx.Commit()
+	return rows
 }
 
-// QueryTime gets the latest timestamp for a specific provider
-func QueryTime(dbName string, netProvider string, provider string) time.Time {
-	DB := connectDb(dbName)
-	defer DB.Close()
-	var p Row
-	err := DB.QueryRowx(querySQL, netProvider, provider).StructScan(&p)
+// QueryTime gets the latest timestamp for a specific image of a provider
+func QueryTime(dbName string, netProvider string, provider string, imageIndex int) time.Time {
+	store := mustOpen(dbName)
+	defer store.Close()
+
+	timestamp, err := store.LatestTime(netProvider, provider, imageIndex)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return time.Time{}
-		}
-		log.Fatalf("Error finding the timestamp for %q -> %q\n", netProvider, provider)
+		log.Fatalf("Error finding the timestamp for %q -> %q: %s", netProvider, provider, err)
 	}
-	return p.Timestamp
+	return timestamp
 }
 
-func fixPercent(s string) float64 {
-	// remove the percent sign at the end
-	res := strings.ReplaceAll(s, "%", "")
-
-	return fixNumber(res)
-}
+// QueryLatest returns the rows of the latest results of each provider. An
+// empty netProvider matches all net providers, and provider matches all
+// providers whose name contains it.
+func QueryLatest(dbName string, netProvider string, provider string) []Row {
+	store := mustOpen(dbName)
+	defer store.Close()
 
-// all numbers present in the table should have precision 2
-func fixNumber(s string) float64 {
-	rgx := regexp.MustCompile(`[^\d]`)
-	res := rgx.ReplaceAllString(s, "")
-	if len(res) < 3 {
-		return 0
-	}
-
-	idx := len(res) - 2
-	res = res[:idx] + "." + res[idx:]
-
-	sNum, err := strconv.ParseFloat(res, 64)
+	rows, err := store.Latest(Filter{NetProvider: netProvider, Provider: provider})
 	if err != nil {
-		log.Fatalf("Error parsing %q -> %q: %s", s, res, err.Error())
+		log.Fatalf("Error querying the latest results: %s", err)
 	}
-
-	return sNum
+	return rows
 }
 
-// AvgSpeed and MaxSpeed have units at the end (KB, MB or GB)
-func fixSpeed(s string) float64 {
-	if len(s) < 3 {
-		return 0
-	}
-	idx := len(s) - 2
-	unit := s[idx:]
-	scalar := 1.0
-	switch unit {
-	case "KB":
-		scalar = float64(1e3)
-	case "MB":
-		scalar = float64(1e6)
-	case "GB":
-		scalar = float64(1e9)
-	}
-	speed := fixNumber(s[:idx])
+// minConfidence is the lowest parse.Number.Confidence of a number that is
+// StatusOK. It's below two fixes, but above a guessed decimal point.
+const minConfidence = 0.6
 
-	return speed * scalar
+// parseCell parses the text of a cell, and returns nil unless its status is
+// StatusOK or StatusUncertain.
+func parseCell(text string, fn func(string) (parse.Number, error)) (*float64, string) {
+	n, err := fn(text)
+	switch {
+	case err == parse.ErrNotTested:
+		return nil, StatusNotTested
+	case err != nil:
+		return nil, StatusUnparseable
+	case n.Confidence < minConfidence:
+		return &n.Value, StatusUncertain
+	}
+	return &n.Value, StatusOK
 }
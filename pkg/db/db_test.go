@@ -1,17 +1,324 @@
 package db
 
-import "testing"
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
-func TestFixNumber(t *testing.T) {
-	s1 := "7145"
-	res1 := fixNumber(s1)
-	if res1 != 71.45 {
-		t.Fatalf("%f is not 71.45\n", res1)
+func float(v float64) *float64 {
+	return &v
+}
+
+func equal(p *float64, v float64) bool {
+	return p != nil && *p == v
+}
+
+// tempDB returns the path of a new database in a directory that is removed
+// after the test.
+func tempDB(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "test.db")
+}
+
+func TestReplaceRows(t *testing.T) {
+	dbName := tempDB(t)
+
+	timestamp := time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+	tbl := [][]string{
+		{"Group", "Group"},
+		{"node 1", "node 2"},
+		{"0.00%", "1.00%"},
+		{"5213", "6021"},
+		{"14452", "15037"},
+		{"21.48MB", "9.15MB"},
+	}
+	InsertRows(dbName, "联通", "ssrcloud", 0, timestamp, tbl)
+	ReplaceRows(dbName, "联通", "ssrcloud", 0, timestamp, tbl)
+
+	store := mustOpen(dbName).(*sqlStore)
+	defer store.Close()
+	var count int
+	if err := store.db.Get(&count, "SELECT COUNT(*) FROM measurements"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Found %d rows after replacing, should be 2", count)
+	}
+	if res := QueryTime(dbName, "联通", "ssrcloud", 0); !res.Equal(timestamp) {
+		t.Errorf("Latest timestamp is %s, should be %s", res, timestamp)
 	}
 
-	s2 := "714.5"
-	res2 := fixNumber(s2)
-	if res2 != 71.45 {
-		t.Fatalf("%f is not 71.45\n", res2)
+	// Other images of the same provider have their own timestamps
+	InsertRows(dbName, "联通", "ssrcloud", 1, timestamp.Add(time.Hour), tbl)
+	if res := QueryTime(dbName, "联通", "ssrcloud", 0); !res.Equal(timestamp) {
+		t.Errorf("Latest timestamp of image 0 is %s, should be %s", res, timestamp)
+	}
+}
+
+func TestQueryLatest(t *testing.T) {
+	dbName := tempDB(t)
+
+	timestamp := time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+	tbl := [][]string{{"Group"}, {"node 1"}, {"0.00%"}, {"5213"}, {"14452"}, {"21.48MB"}}
+	InsertRows(dbName, "联通", "ssrcloud", 0, timestamp.Add(-24*time.Hour), tbl)
+	InsertRows(dbName, "联通", "ssrcloud", 0, timestamp, tbl)
+	InsertRows(dbName, "电信", "ssrcloud", 0, timestamp, tbl)
+	InsertRows(dbName, "联通", "N3RO", 0, timestamp, tbl)
+
+	if rows := QueryLatest(dbName, "", ""); len(rows) != 3 {
+		t.Errorf("Found %d latest rows, should be 3", len(rows))
+	}
+	rows := QueryLatest(dbName, "联通", "SSRCloud")
+	if len(rows) != 1 {
+		t.Fatalf("Found %d latest rows for 联通 -> ssrcloud, should be 1", len(rows))
+	}
+	if !rows[0].Timestamp.Equal(timestamp) || !equal(rows[0].AvgSpeed, 21.48e6) {
+		t.Errorf("Latest row is %+v", rows[0])
+	}
+}
+
+func TestReparse(t *testing.T) {
+	dbName := tempDB(t)
+
+	timestamp := time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+	tbl := [][]string{{"Group", "Group"}, {"node 1", "node 2"}, {"0.00%", "N/A"}, {"5213", "N/A"}, {"14452", "N/A"}, {"21.48MB", "N/A"}}
+	InsertRows(dbName, "联通", "ssrcloud", 0, timestamp, tbl)
+
+	store := mustOpen(dbName).(*sqlStore)
+	defer store.Close()
+	// Values saved by an older parser, and a row saved before the text was
+	// kept
+	store.db.MustExec("UPDATE measurements SET avg_speed = 0, avg_speed_status = 'unparseable', ping = 5213 WHERE position = 0")
+	store.db.MustExec("UPDATE measurements SET loss_text = '', ping_text = '', google_ping_text = '', avg_speed_text = '' WHERE position = 1")
+
+	read, changed, err := store.Reparse("", "")
+	if err != nil || read != 1 || changed != 1 {
+		t.Errorf("Reparsing read %d and changed %d measurements, %v", read, changed, err)
+	}
+	rows, err := store.Latest(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rows[0]
+	if !equal(r.AvgSpeed, 21.48e6) || r.AvgSpeedStatus != StatusOK || !equal(r.Ping, 52.13) || r.MaxSpeedStatus != StatusNotTested {
+		t.Errorf("Row after reparsing is %+v", r)
+	}
+	if rows[1].AvgSpeed != nil || rows[1].AvgSpeedStatus != StatusNotTested {
+		t.Errorf("Row without text after reparsing is %+v", rows[1])
+	}
+}
+
+func TestConvertLegacy(t *testing.T) {
+	dbName := tempDB(t)
+
+	// The table of old versions, before max_speed and udp_nat_type were added
+	legacy, err := sql.Open("sqlite3", dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE duyaoss (
+		net_provider TEXT, provider TEXT, image_index INTEGER DEFAULT 0, timestamp DATE,
+		provider_group TEXT, remarks TEXT, loss REAL, ping REAL, google_ping REAL, avg_speed REAL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+	insert := `INSERT INTO duyaoss VALUES (?, ?, 0, ?, 'Group', ?, 0, 52.13, 144.52, ?)`
+	for _, args := range [][]interface{}{
+		{"联通", "ssrcloud", timestamp.Add(-24 * time.Hour), "node 1", 1e6},
+		{"联通", "ssrcloud", timestamp.Add(-24 * time.Hour), "node 2", 2e6},
+		{"联通", "ssrcloud", timestamp, "node 2", 3e6},
+		{"联通", "ssrcloud", timestamp, "node 1", 4e6},
+	} {
+		if _, err := legacy.Exec(insert, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy.Close()
+
+	if _, err := Open(dbName); err == nil {
+		t.Fatal("Opened the old database without migrating it")
+	}
+	applied, err := Migrate(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != LatestVersion {
+		t.Errorf("Applied migrations are %+v", applied)
+	}
+	store := mustOpen(dbName).(*sqlStore)
+	defer store.Close()
+	rows, err := store.Latest(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Remarks != "node 2" || !equal(rows[1].AvgSpeed, 4e6) || !rows[0].Timestamp.Equal(timestamp) {
+		t.Errorf("Latest rows after converting are %+v", rows)
+	}
+	rows, err = store.History("联通", "ssrcloud", "node 1", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || !equal(rows[0].AvgSpeed, 1e6) || !equal(rows[1].AvgSpeed, 4e6) {
+		t.Errorf("History after converting is %+v", rows)
+	}
+
+	var count int
+	if err := store.db.Get(&count, "SELECT COUNT(*) FROM duyaoss_v1"); err != nil || count != 4 {
+		t.Errorf("Found %d rows in the old table, should be 4: %v", count, err)
+	}
+}
+
+func TestConvertLegacyWithoutIndex(t *testing.T) {
+	dbName := tempDB(t)
+
+	// The table of versions before multiple images per provider, which
+	// CREATE TABLE IF NOT EXISTS never gave an image_index column
+	legacy, err := sql.Open("sqlite3", dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE duyaoss (
+		net_provider TEXT, provider TEXT, timestamp DATE, provider_group TEXT, remarks TEXT,
+		loss REAL, ping REAL, google_ping REAL, avg_speed REAL,
+		max_speed REAL DEFAULT 0, udp_nat_type TEXT DEFAULT ''
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+	_, err = legacy.Exec(`INSERT INTO duyaoss VALUES ('联通', 'ssrcloud', ?, 'Group', 'node 1', 0, 52.13, 144.52, 1e6, 2e6, 'Full Cone')`,
+		timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	if _, err := Migrate(dbName); err != nil {
+		t.Fatal(err)
+	}
+	store := mustOpen(dbName)
+	defer store.Close()
+	rows, err := store.Latest(Filter{})
+	if err != nil || len(rows) != 1 || rows[0].ImageIndex != 0 || !equal(rows[0].MaxSpeed, 2e6) {
+		t.Errorf("Latest rows after converting are %+v, %v", rows, err)
+	}
+	if latest, err := store.LatestTime("联通", "ssrcloud", 0); err != nil || !latest.Equal(timestamp) {
+		t.Errorf("Latest time after converting is %v, %v", latest, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dbName := tempDB(t)
+	dir := filepath.Dir(dbName)
+
+	// New databases are set up when they are opened
+	mustOpen(dbName).Close()
+	version, pending, err := Status(dbName)
+	if err != nil || version != LatestVersion || len(pending) != 0 {
+		t.Errorf("Status of a new database is %d, %+v, %v", version, pending, err)
+	}
+	missing := filepath.Join(dir, "missing.db")
+	if _, _, err := Status(missing); !os.IsNotExist(err) {
+		t.Errorf("Status of a missing database returned %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Status created a missing database: %v", err)
+	}
+
+	// A database from before the unique keys, with a duplicate image
+	oldName := filepath.Join(dir, "old.db")
+	s, err := connect(oldName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.migrate(2); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+	snap := Snapshot{NetProvider: "联通", Provider: "ssrcloud", Timestamp: timestamp}
+	zero := float(0)
+	rows := []Row{
+		{Remarks: "node 1", AvgSpeed: float(1e6), MaxSpeed: zero},
+		// Cells like N/A were saved as 0
+		{Remarks: "node 2", Loss: zero, Ping: zero, AvgSpeed: float(2e6), MaxSpeed: zero},
+	}
+	for i := 0; i < 2; i++ {
+		tx := s.db.MustBegin()
+		if err := s.convertImage(tx, snap, rows); err != nil {
+			t.Fatal(err)
+		}
+		tx.Commit()
+	}
+	s.Close()
+
+	if _, err := Open(oldName); err == nil {
+		t.Error("Opened an outdated database")
+	}
+	version, pending, err = Status(oldName)
+	if err != nil || version != 2 || len(pending) != LatestVersion-2 || pending[0].Version != 3 {
+		t.Errorf("Status of an outdated database is %d, %+v, %v", version, pending, err)
+	}
+	applied, err := Migrate(oldName)
+	if err != nil || len(applied) != LatestVersion-2 {
+		t.Errorf("Applied migrations are %+v, %v", applied, err)
+	}
+	if applied, err := Migrate(oldName); err != nil || len(applied) != 0 {
+		t.Errorf("Applied migrations to an up to date database: %+v, %v", applied, err)
+	}
+
+	store := mustOpen(oldName).(*sqlStore)
+	var count int
+	if err := store.db.Get(&count, "SELECT COUNT(*) FROM measurements"); err != nil || count != 2 {
+		t.Errorf("Found %d measurements after removing duplicates, should be 2: %v", count, err)
+	}
+	rows, err = store.Latest(Filter{})
+	if err != nil || len(rows) != 2 || !equal(rows[1].AvgSpeed, 2e6) || rows[1].AvgSpeedStatus != StatusOK {
+		t.Errorf("Latest rows after migrating are %+v, %v", rows, err)
+	}
+	if r := rows[1]; !equal(r.Loss, 0) || r.LossStatus != StatusOK || r.Ping != nil || r.PingStatus != StatusNotTested ||
+		r.MaxSpeed != nil || r.MaxSpeedStatus != StatusNotTested {
+		t.Errorf("Zero values after migrating are %+v", r)
+	}
+
+	// Databases of newer versions aren't touched
+	store.db.MustExec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', ?)",
+		LatestVersion+1, time.Now())
+	store.Close()
+	if _, err := Open(oldName); err == nil {
+		t.Error("Opened a database of a newer version")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	// Dates don't depend on the time zone of the machine
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("CST", 8*3600)
+
+	day := time.Date(2020, 12, 11, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		s    string
+		end  bool
+		want time.Time
+	}{
+		{"", false, time.Time{}},
+		{"", true, time.Time{}},
+		{"2020-12-11T20:30:03Z", true, time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)},
+		{"2020-12-11", false, day},
+		// The whole day is included at the end of a range
+		{"2020-12-11", true, day.AddDate(0, 0, 1)},
+	}
+	for _, c := range cases {
+		if got, err := ParseTime(c.s, c.end); err != nil || !got.Equal(c.want) {
+			t.Errorf("ParseTime(%q, %v) is %v, %v, should be %v", c.s, c.end, got, err, c.want)
+		}
+	}
+	if _, err := ParseTime("yesterday", false); err == nil {
+		t.Error("Parsed an invalid time")
 	}
 }
@@ -0,0 +1,414 @@
+// Package dbtest is the conformance suite that every db.Store must pass.
+package dbtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+// Timestamp is the time of the latest snapshots inserted by the tests.
+var Timestamp = time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+
+// Table has the columns of a result table with a UDP NAT type.
+var Table = [][]string{
+	{"Group", "Group", "Group"},
+	{"HK 01", "JP 01", "US_01%"},
+	{"0.00%", "1.00%", "100.00%"},
+	{"5213", "6021", "0"},
+	{"14452", "15037", "0"},
+	{"21.48MB", "9.15MB", "0B"},
+	{"Full Cone", "Symmetric", "Unknown"},
+}
+
+// Run runs the suite. open must return an empty store for each test.
+func Run(t *testing.T, open func(t *testing.T) db.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store db.Store)
+	}{
+		{"LatestTime", testLatestTime},
+		{"ReplaceSnapshot", testReplaceSnapshot},
+		{"InsertTwice", testInsertTwice},
+		{"Latest", testLatest},
+		{"History", testHistory},
+		{"Providers", testProviders},
+		{"Runs", testRuns},
+		{"Cells", testCells},
+		{"Reparse", testReparse},
+		{"Export", testExport},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+			tt.fn(t, store)
+		})
+	}
+}
+
+func equal(p *float64, v float64) bool {
+	return p != nil && *p == v
+}
+
+func insert(t *testing.T, store db.Store, netProvider string, provider string, imageIndex int, timestamp time.Time) {
+	t.Helper()
+	err := store.InsertSnapshot(db.Snapshot{
+		NetProvider: netProvider,
+		Provider:    provider,
+		ImageIndex:  imageIndex,
+		Timestamp:   timestamp,
+		Table:       Table,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testLatestTime(t *testing.T, store db.Store) {
+	res, err := store.LatestTime("联通", "ssrcloud", 0)
+	if err != nil || !res.IsZero() {
+		t.Errorf("Latest timestamp of an empty store is %s, %v", res, err)
+	}
+
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp.Add(-24*time.Hour))
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	insert(t, store, "联通", "ssrcloud", 1, Timestamp.Add(time.Hour))
+
+	res, err = store.LatestTime("联通", "ssrcloud", 0)
+	if err != nil || !res.Equal(Timestamp) {
+		t.Errorf("Latest timestamp is %s, %v, should be %s", res, err, Timestamp)
+	}
+	res, err = store.LatestTime("电信", "ssrcloud", 0)
+	if err != nil || !res.IsZero() {
+		t.Errorf("Latest timestamp of another net provider is %s, %v", res, err)
+	}
+}
+
+func testReplaceSnapshot(t *testing.T, store db.Store) {
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	tbl := [][]string{{"Group"}, {"HK 02"}, {"0.00%"}, {"5213"}, {"14452"}, {"21.48MB"}}
+	err := store.ReplaceSnapshot(db.Snapshot{
+		NetProvider: "联通",
+		Provider:    "ssrcloud",
+		Timestamp:   Timestamp,
+		Table:       tbl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := store.Latest(db.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Remarks != "HK 02" {
+		t.Errorf("Rows after replacing are %+v", rows)
+	}
+}
+
+func testInsertTwice(t *testing.T, store db.Store) {
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	err := store.InsertSnapshot(db.Snapshot{
+		NetProvider: "联通",
+		Provider:    "ssrcloud",
+		Timestamp:   Timestamp,
+		Table:       Table,
+	})
+	if err != db.ErrExists {
+		t.Errorf("Inserting the same image again returned %v", err)
+	}
+
+	// A node that is read twice from a table is saved once
+	tbl := [][]string{
+		{"Group", "Group"}, {"HK 01", "HK 01"}, {"0.00%", "0.00%"},
+		{"5213", "5213"}, {"14452", "14452"}, {"21.48MB", "9.15MB"},
+	}
+	err = store.InsertSnapshot(db.Snapshot{
+		NetProvider: "联通",
+		Provider:    "ssrcloud",
+		Timestamp:   Timestamp.Add(time.Hour),
+		Table:       tbl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := store.History("联通", "ssrcloud", "HK 01", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || !equal(rows[1].AvgSpeed, 9.15e6) {
+		t.Errorf("History of HK 01 is %+v", rows)
+	}
+}
+
+func testLatest(t *testing.T, store db.Store) {
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp.Add(-24*time.Hour))
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	insert(t, store, "电信", "ssrcloud", 0, Timestamp)
+	insert(t, store, "联通", "N3RO", 0, Timestamp)
+
+	rows, err := store.Latest(db.Filter{NetProvider: "联通", Provider: "SSRCloud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Found %d latest rows for 联通 -> ssrcloud, should be 3", len(rows))
+	}
+	// Rows keep the order of the table
+	r := rows[0]
+	if r.Remarks != "HK 01" || rows[2].Remarks != "US_01%" {
+		t.Errorf("Latest rows are %+v", rows)
+	}
+	if !r.Timestamp.Equal(Timestamp) || !equal(r.Loss, 0) || !equal(r.Ping, 52.13) ||
+		!equal(r.GooglePing, 144.52) || !equal(r.AvgSpeed, 21.48e6) || r.UDPNATType != "Full Cone" {
+		t.Errorf("Latest row is %+v", r)
+	}
+	// A failed node shows pings and speeds of 0, which weren't measured
+	if r := rows[2]; !equal(r.Loss, 100) || r.LossStatus != db.StatusOK ||
+		r.Ping != nil || r.PingStatus != db.StatusNotTested || r.PingText != "0" ||
+		r.AvgSpeed != nil || r.AvgSpeedStatus != db.StatusNotTested {
+		t.Errorf("Row of a failed node is %+v", r)
+	}
+
+	cases := []struct {
+		name string
+		f    db.Filter
+		want int
+	}{
+		{"all", db.Filter{}, 9},
+		{"net provider", db.Filter{NetProvider: "电信"}, 3},
+		{"remarks", db.Filter{Remarks: "hk"}, 3},
+		{"NAT type", db.Filter{UDPNATType: "full cone"}, 3},
+		{"min speed", db.Filter{MinSpeed: 1e7}, 3},
+		{"wildcard", db.Filter{Remarks: "_"}, 3},
+		{"percent", db.Filter{Remarks: "%"}, 3},
+		{"no match", db.Filter{Provider: "n3ro", Remarks: "JP", MinSpeed: 1e7}, 0},
+	}
+	for _, c := range cases {
+		rows, err := store.Latest(c.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != c.want {
+			t.Errorf("Found %d rows with filter %s, should be %d", len(rows), c.name, c.want)
+		}
+	}
+}
+
+func testHistory(t *testing.T, store db.Store) {
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp.Add(-48*time.Hour))
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp.Add(-24*time.Hour))
+
+	rows, err := store.History("联通", "ssrcloud", "HK 01", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || !rows[0].Timestamp.Equal(Timestamp.Add(-48*time.Hour)) || !rows[2].Timestamp.Equal(Timestamp) {
+		t.Fatalf("History is %+v", rows)
+	}
+
+	rows, err = store.History("联通", "ssrcloud", "HK 01", Timestamp.Add(-30*time.Hour), Timestamp.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || !rows[0].Timestamp.Equal(Timestamp.Add(-24*time.Hour)) {
+		t.Errorf("History of the day before is %+v", rows)
+	}
+
+	rows, err = store.History("联通", "ssrcloud", "HK 01", Timestamp.Add(-24*time.Hour), Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || !rows[0].Timestamp.Equal(Timestamp.Add(-24*time.Hour)) {
+		t.Errorf("History from the day before up to the latest results is %+v", rows)
+	}
+}
+
+func testProviders(t *testing.T, store db.Store) {
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp.Add(-24*time.Hour))
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	insert(t, store, "联通", "ssrcloud", 1, Timestamp)
+	insert(t, store, "电信", "N3RO", 0, Timestamp)
+
+	providers, err := store.Providers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 2 {
+		t.Fatalf("Providers are %+v", providers)
+	}
+	p := providers[0]
+	if p.NetProvider != "电信" || p.Provider != "N3RO" {
+		t.Errorf("Providers aren't sorted: %+v", providers)
+	}
+	p = providers[1]
+	if p.Images != 2 || p.Snapshots != 3 || !p.LastUpdated.Equal(Timestamp) {
+		t.Errorf("Provider is %+v", p)
+	}
+}
+
+func testRuns(t *testing.T, store db.Store) {
+	first, err := store.StartRun("crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.StartRun("crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == 0 || first == second {
+		t.Errorf("Run IDs are %d and %d", first, second)
+	}
+
+	err = store.InsertSnapshot(db.Snapshot{
+		NetProvider: "联通",
+		Provider:    "ssrcloud",
+		Timestamp:   Timestamp,
+		Table:       Table,
+		RunID:       second,
+		URL:         "https://example.com/ssrcloud.png",
+		SHA256:      "0123456789abcdef",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.FinishRun(second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCells(t *testing.T, store db.Store) {
+	tbl := [][]string{
+		{"Group", "Group"},
+		{"HK 01", "JP 01"},
+		{"N/A", "0.00%"},
+		{"NA", "5213"},
+		{"n/a", "14452"},
+		{"N/A", "2l.4"},
+		{"N/A", "9.15MB"},
+		{"", "Full Cone"},
+	}
+	err := store.InsertSnapshot(db.Snapshot{
+		NetProvider: "联通",
+		Provider:    "ssrcloud",
+		Timestamp:   Timestamp,
+		Table:       tbl,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := store.Latest(db.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Found %d rows, should be 2", len(rows))
+	}
+	r := rows[0]
+	if r.Loss != nil || r.Ping != nil || r.GooglePing != nil || r.AvgSpeed != nil || r.MaxSpeed != nil ||
+		r.LossStatus != db.StatusNotTested || r.AvgSpeedStatus != db.StatusNotTested ||
+		r.MaxSpeedStatus != db.StatusNotTested || r.PingText != "NA" {
+		t.Errorf("Row of a node that wasn't tested is %+v", r)
+	}
+	r = rows[1]
+	if r.AvgSpeed != nil || r.AvgSpeedStatus != db.StatusUnparseable || r.AvgSpeedText != "2l.4" {
+		t.Errorf("Row with an unreadable speed is %+v", r)
+	}
+	if !equal(r.Loss, 0) || r.LossStatus != db.StatusOK || r.LossText != "0.00%" ||
+		!equal(r.MaxSpeed, 9.15e6) || r.MaxSpeedStatus != db.StatusOK {
+		t.Errorf("Row with an unreadable speed is %+v", r)
+	}
+	// The decimal point of pings without one is guessed
+	if !equal(r.Ping, 52.13) || r.PingStatus != db.StatusUncertain || r.PingText != "5213" ||
+		!equal(r.GooglePing, 144.52) || r.GooglePingStatus != db.StatusUncertain {
+		t.Errorf("Row with guessed decimal points is %+v", r)
+	}
+
+	// Rows without a speed only match without a minimum speed
+	rows, err = store.Latest(db.Filter{MinSpeed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("Rows without a speed match a minimum speed: %+v", rows)
+	}
+}
+
+func testReparse(t *testing.T, store db.Store) {
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	insert(t, store, "联通", "N3RO", 0, Timestamp)
+
+	read, changed, err := store.Reparse("联通", "SSR")
+	if err != nil || read != 3 || changed != 0 {
+		t.Errorf("Reparsing read %d and changed %d measurements, %v", read, changed, err)
+	}
+	read, changed, err = store.Reparse("", "")
+	if err != nil || read != 6 || changed != 0 {
+		t.Errorf("Reparsing everything read %d and changed %d measurements, %v", read, changed, err)
+	}
+}
+
+func testExport(t *testing.T, store db.Store) {
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp.Add(-24*time.Hour))
+	insert(t, store, "联通", "ssrcloud", 0, Timestamp)
+	insert(t, store, "电信", "ssrcloud", 0, Timestamp)
+	insert(t, store, "联通", "N3RO", 0, Timestamp)
+
+	var rows []db.Row
+	err := store.Export(db.ExportFilter{NetProvider: "联通", Provider: "SSR"}, func(r db.Row) error {
+		rows = append(rows, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 6 || !rows[0].Timestamp.Before(rows[3].Timestamp) || rows[3].Remarks != "HK 01" {
+		t.Errorf("Exported rows are %+v", rows)
+	}
+
+	cases := []struct {
+		name string
+		f    db.ExportFilter
+		want int
+	}{
+		{"all", db.ExportFilter{}, 12},
+		{"latest", db.ExportFilter{LatestOnly: true}, 9},
+		{"from", db.ExportFilter{From: Timestamp.Add(-time.Hour)}, 9},
+		{"to", db.ExportFilter{To: Timestamp.Add(-time.Hour)}, 3},
+		// from is inclusive and to exclusive, in any time zone
+		{"from exactly", db.ExportFilter{From: Timestamp.In(time.FixedZone("CST", 8*3600))}, 9},
+		{"to exactly", db.ExportFilter{To: Timestamp.In(time.FixedZone("CST", 8*3600))}, 3},
+		{"no match", db.ExportFilter{Provider: "n3ro", To: Timestamp.Add(-time.Hour)}, 0},
+	}
+	for _, c := range cases {
+		count := 0
+		err := store.Export(c.f, func(db.Row) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != c.want {
+			t.Errorf("Exported %d rows with filter %s, should be %d", count, c.name, c.want)
+		}
+	}
+
+	// Errors stop the export
+	errStop := errors.New("stop")
+	count := 0
+	err = store.Export(db.ExportFilter{}, func(db.Row) error {
+		count++
+		return errStop
+	})
+	if err != errStop || count != 1 {
+		t.Errorf("Export returned %v after %d rows", err, count)
+	}
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// legacyTable is the single table that older versions saved all rows to.
+// After the conversion it's renamed to legacyBackup, which can be dropped
+// once the new tables are checked.
+const (
+	legacyTable  = "duyaoss"
+	legacyBackup = "duyaoss_v1"
+)
+
+// legacyDefaults are the values of the columns that were added to the
+// legacy table later. CREATE TABLE IF NOT EXISTS never added them to
+// existing databases.
+var legacyDefaults = map[string]string{
+	"image_index":  "0",
+	"max_speed":    "0",
+	"udp_nat_type": "''",
+}
+
+// convertLegacy moves the rows of the legacy table to the normalized tables,
+// if there is one. Rows with the same provider, image index and timestamp
+// become one image, in the order they were inserted.
+func (s *sqlStore) convertLegacy(tx *sqlx.Tx) error {
+	exists, err := s.tableExists(tx, legacyTable)
+	if err != nil || !exists {
+		return err
+	}
+
+	query, err := s.legacySelect(tx)
+	if err != nil {
+		return err
+	}
+	var rows []Row
+	if err := tx.Select(&rows, query); err != nil {
+		return fmt.Errorf("reading %s: %s", legacyTable, err)
+	}
+
+	images := 0
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && sameImage(rows[start], rows[end]) {
+			end++
+		}
+		r := rows[start]
+		snap := Snapshot{
+			NetProvider: r.NetProvider,
+			Provider:    r.Provider,
+			ImageIndex:  r.ImageIndex,
+			Timestamp:   r.Timestamp,
+		}
+		if err := s.convertImage(tx, snap, rows[start:end]); err != nil {
+			return fmt.Errorf("converting %s: %s", legacyTable, err)
+		}
+		images++
+		start = end
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", legacyTable, legacyBackup)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"rows": len(rows), "images": images}).
+		Infof("Converted the %s table, the old rows are kept in %s", legacyTable, legacyBackup)
+	return nil
+}
+
+// legacySelect selects the columns of Row from the legacy table, with
+// defaults for the columns that are missing.
+func (s *sqlStore) legacySelect(tx *sqlx.Tx) (string, error) {
+	res, err := tx.Queryx(fmt.Sprintf("SELECT * FROM %s LIMIT 0;", legacyTable))
+	if err != nil {
+		return "", err
+	}
+	cols, err := res.Columns()
+	res.Close()
+	if err != nil {
+		return "", err
+	}
+	found := make(map[string]bool)
+	for _, c := range cols {
+		found[c] = true
+	}
+
+	var selected []string
+	for _, c := range strings.Split(strings.Join(strings.Fields(legacyColumns), ""), ",") {
+		switch {
+		case found[c]:
+			selected = append(selected, c)
+		case legacyDefaults[c] != "":
+			selected = append(selected, legacyDefaults[c]+" AS "+c)
+		default:
+			return "", fmt.Errorf("%s has no %s column", legacyTable, c)
+		}
+	}
+	return fmt.Sprintf("SELECT %s FROM %s ORDER BY %s;",
+		strings.Join(selected, ", "), legacyTable, s.rowOrder), nil
+}
+
+// The conversion was released before the unique keys were added, so it
+// can't use the upserts of sqlStore.insertTx.
+
+var insertProviderSQL = `INSERT INTO providers (net_provider, name) VALUES (?, ?)`
+
+var insertImageSQL = `
+INSERT INTO images (provider_id, run_id, image_index, timestamp, url, sha256)
+VALUES (?, ?, ?, ?, ?, ?)`
+
+var selectNodeSQL = `SELECT id, provider_group FROM nodes WHERE provider_id =? AND remarks =?;`
+
+var insertNodeSQL = `INSERT INTO nodes (provider_id, remarks, provider_group) VALUES (?, ?, ?)`
+
+var updateNodeSQL = `UPDATE nodes SET provider_group =? WHERE id =?;`
+
+var insertMeasurementSQL = `
+INSERT INTO measurements (
+	image_id, node_id, position,
+	loss, ping, google_ping, avg_speed, max_speed, udp_nat_type
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+`
+
+var countMeasurementsSQL = `SELECT COUNT(*) FROM measurements WHERE image_id =?;`
+
+// legacyColumns are the columns of Row in the legacy table.
+var legacyColumns = `
+	net_provider, provider, image_index, timestamp, provider_group, remarks,
+	loss, ping, google_ping, avg_speed, max_speed, udp_nat_type`
+
+func sameImage(a Row, b Row) bool {
+	return a.NetProvider == b.NetProvider && a.Provider == b.Provider &&
+		a.ImageIndex == b.ImageIndex && a.Timestamp.Equal(b.Timestamp)
+}
+
+// convertImage adds the rows of an image of the legacy table. Rows of an
+// image that was added before are appended to it.
+func (s *sqlStore) convertImage(tx *sqlx.Tx, snap Snapshot, rows []Row) error {
+	providerID, err := s.getOrInsert(tx,
+		selectProviderSQL, []interface{}{snap.NetProvider, snap.Provider},
+		insertProviderSQL, snap.NetProvider, snap.Provider)
+	if err != nil {
+		return err
+	}
+	imageID, err := s.getOrInsert(tx,
+		selectImageSQL, []interface{}{providerID, snap.ImageIndex, snap.Timestamp},
+		insertImageSQL, providerID, nil, snap.ImageIndex, snap.Timestamp, "", "")
+	if err != nil {
+		return err
+	}
+	var position int
+	if err := tx.Get(&position, tx.Rebind(countMeasurementsSQL), imageID); err != nil {
+		return err
+	}
+
+	for i, r := range rows {
+		nodeID, err := s.convertNode(tx, providerID, r.Remarks, r.Group)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind(insertMeasurementSQL), imageID, nodeID, position+i,
+			r.Loss, r.Ping, r.GooglePing, r.AvgSpeed, r.MaxSpeed, r.UDPNATType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getOrInsert returns the ID of the row with a key, adding it with values
+// if it doesn't exist.
+func (s *sqlStore) getOrInsert(tx *sqlx.Tx, query string, key []interface{}, insert string, values ...interface{}) (int64, error) {
+	var id int64
+	err := tx.Get(&id, tx.Rebind(query), key...)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	return s.insertID(tx, insert, values...)
+}
+
+// convertNode returns the ID of a node, adding it if it's new. The group of
+// the node is updated if it changed.
+func (s *sqlStore) convertNode(tx *sqlx.Tx, providerID int64, remarks string, group string) (int64, error) {
+	var node struct {
+		ID    int64  `db:"id"`
+		Group string `db:"provider_group"`
+	}
+	err := tx.Get(&node, tx.Rebind(selectNodeSQL), providerID, remarks)
+	if err == sql.ErrNoRows {
+		return s.insertID(tx, insertNodeSQL, providerID, remarks, group)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if node.Group != group {
+		_, err = tx.Exec(tx.Rebind(updateNodeSQL), group, node.ID)
+	}
+	return node.ID, err
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// migration upgrades the schema by one version. Migrations are applied in
+// order, each in a transaction with the row that records it, and must never
+// change once released: add a new one instead.
+type migration struct {
+	name     string
+	sqlite   string
+	postgres string
+	// run is called after the SQL, for changes that need Go code
+	run func(s *sqlStore, tx *sqlx.Tx) error
+}
+
+// migrations are the versions of the schema. The version of a migration is
+// its position in the list, starting at 1.
+var migrations = []migration{
+	{name: "create tables", sqlite: schema, postgres: pgSchema},
+	{name: "convert the duyaoss table", run: (*sqlStore).convertLegacy},
+	{name: "add unique keys", sqlite: uniqueKeysSQL, postgres: uniqueKeysSQL},
+	{name: "add the status and text of values", sqlite: nullableSQL, postgres: pgNullableSQL},
+	{name: "mark zero values without text as not tested", sqlite: zeroValuesSQL, postgres: zeroValuesSQL},
+}
+
+var versionSchema = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version     INTEGER    PRIMARY KEY,
+	name        TEXT       NOT NULL,
+	applied_at  TIMESTAMP  NOT NULL
+);
+`
+
+var versionSQL = `SELECT COALESCE(MAX(version), 0) FROM schema_version;`
+
+var insertVersionSQL = `INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?);`
+
+// pgLockSQL makes concurrent migrations of PostgreSQL wait for each other.
+var pgLockSQL = `LOCK TABLE schema_version IN EXCLUSIVE MODE;`
+
+// Migration is a version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+}
+
+// LatestVersion is the schema version that this version of the code uses.
+var LatestVersion = len(migrations)
+
+// Status returns the schema version of a database and the migrations that
+// are yet to be applied. The database must exist, and is only read.
+func Status(dsn string) (int, []Migration, error) {
+	s, err := connectReadOnly(dsn)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer s.Close()
+
+	version, err := s.version()
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, pending(version), nil
+}
+
+// Migrate upgrades the schema of a database to LatestVersion, and returns
+// the migrations that were applied.
+func Migrate(dsn string) ([]Migration, error) {
+	s, err := connect(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.migrate(LatestVersion)
+}
+
+func pending(version int) []Migration {
+	var res []Migration
+	for i := version; i < len(migrations); i++ {
+		res = append(res, Migration{Version: i + 1, Name: migrations[i].name})
+	}
+	return res
+}
+
+// checkVersion makes sure the schema is up to date before the store is
+// used. New databases are set up right away if setup is set, but existing
+// ones are only upgraded by Migrate, e.g. with the migrate command after a
+// backup.
+func (s *sqlStore) checkVersion(setup bool) error {
+	version, err := s.version()
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		empty, err := s.empty()
+		if err != nil {
+			return err
+		}
+		if empty && !setup {
+			return errors.New("the database has no tables, check that its path or URL is right")
+		}
+		if empty {
+			_, err := s.migrate(LatestVersion)
+			return err
+		}
+	}
+	if version < LatestVersion {
+		return fmt.Errorf("the database schema is at version %d, run the migrate command to upgrade it to version %d",
+			version, LatestVersion)
+	}
+	if version > LatestVersion {
+		return fmt.Errorf("the database schema is at version %d, which is newer than this program (version %d)",
+			version, LatestVersion)
+	}
+	return nil
+}
+
+// version returns the latest migration that was applied, or 0.
+func (s *sqlStore) version() (int, error) {
+	exists, err := s.tableExists(s.db, "schema_version")
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = s.db.Get(&version, versionSQL)
+	return version, err
+}
+
+// empty reports whether the database has none of the tables of any
+// version, i.e. it doesn't need to be migrated with care.
+func (s *sqlStore) empty() (bool, error) {
+	for _, table := range []string{legacyTable, "images"} {
+		exists, err := s.tableExists(s.db, table)
+		if err != nil || exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// tableExists is called with the transaction of a migration, as SQLite has
+// a single connection that the transaction holds.
+func (s *sqlStore) tableExists(q sqlx.Queryer, name string) (bool, error) {
+	var count int
+	err := sqlx.Get(q, &count, s.db.Rebind(s.tablesSQL), name)
+	return count > 0, err
+}
+
+// migrate applies the migrations up to version target.
+func (s *sqlStore) migrate(target int) ([]Migration, error) {
+	if _, err := s.db.Exec(versionSchema); err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for {
+		m, err := s.migrateNext(target)
+		if err != nil || m == nil {
+			return applied, err
+		}
+		applied = append(applied, *m)
+	}
+}
+
+// migrateNext applies the next migration, or returns nil if the schema is
+// at version target or later.
+func (s *sqlStore) migrateNext(target int) (*Migration, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if s.postgres() {
+		if _, err := tx.Exec(pgLockSQL); err != nil {
+			return nil, err
+		}
+	}
+	var version int
+	if err := tx.Get(&version, versionSQL); err != nil {
+		return nil, err
+	}
+	if version >= target {
+		return nil, nil
+	}
+
+	m := migrations[version]
+	res := &Migration{Version: version + 1, Name: m.name}
+	start := time.Now()
+	query := m.sqlite
+	if s.postgres() {
+		query = m.postgres
+	}
+	if query != "" {
+		if _, err := tx.Exec(query); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %s", res.Version, res.Name, err)
+		}
+	}
+	if m.run != nil {
+		if err := m.run(s, tx); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %s", res.Version, res.Name, err)
+		}
+	}
+	if _, err := tx.Exec(tx.Rebind(insertVersionSQL), res.Version, res.Name, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"version":  res.Version,
+		"duration": time.Since(start).Seconds(),
+	}).Infof("Applied migration: %s", res.Name)
+	return res, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // PostgreSQL driver
+)
+
+// pgSchema is the first version of the schema for PostgreSQL, with the same
+// tables as schema.
+var pgSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id           BIGSERIAL    PRIMARY KEY,
+	command      TEXT         NOT NULL,
+	started_at   TIMESTAMPTZ  NOT NULL,
+	finished_at  TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS providers (
+	id            BIGSERIAL  PRIMARY KEY,
+	net_provider  TEXT       NOT NULL,
+	name          TEXT       NOT NULL,
+	UNIQUE (net_provider, name)
+);
+CREATE TABLE IF NOT EXISTS images (
+	id           BIGSERIAL    PRIMARY KEY,
+	provider_id  BIGINT       NOT NULL REFERENCES providers (id),
+	run_id       BIGINT       REFERENCES runs (id),
+	image_index  INTEGER      NOT NULL DEFAULT 0,
+	timestamp    TIMESTAMPTZ  NOT NULL,
+	url          TEXT         NOT NULL DEFAULT '',
+	sha256       TEXT         NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS images_provider_idx
+	ON images (provider_id, image_index, timestamp);
+CREATE TABLE IF NOT EXISTS nodes (
+	id              BIGSERIAL  PRIMARY KEY,
+	provider_id     BIGINT     NOT NULL REFERENCES providers (id),
+	remarks         TEXT       NOT NULL,
+	provider_group  TEXT       NOT NULL DEFAULT '',
+	UNIQUE (provider_id, remarks)
+);
+CREATE TABLE IF NOT EXISTS measurements (
+	id            BIGSERIAL         PRIMARY KEY,
+	image_id      BIGINT            NOT NULL REFERENCES images (id),
+	node_id       BIGINT            NOT NULL REFERENCES nodes (id),
+	position      INTEGER           NOT NULL,
+	loss          DOUBLE PRECISION,
+	ping          DOUBLE PRECISION,
+	google_ping   DOUBLE PRECISION,
+	avg_speed     DOUBLE PRECISION,
+	max_speed     DOUBLE PRECISION  NOT NULL DEFAULT 0,
+	udp_nat_type  TEXT              NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS measurements_image_idx ON measurements (image_id);
+CREATE INDEX IF NOT EXISTS measurements_node_idx ON measurements (node_id);
+`
+
+// pgNullableSQL is nullableSQL for PostgreSQL, which can change the columns
+// in place.
+var pgNullableSQL = `
+ALTER TABLE measurements
+	ALTER COLUMN max_speed DROP NOT NULL,
+	ALTER COLUMN max_speed DROP DEFAULT,
+	ADD COLUMN loss_status         TEXT  NOT NULL DEFAULT 'ok',
+	ADD COLUMN ping_status         TEXT  NOT NULL DEFAULT 'ok',
+	ADD COLUMN google_ping_status  TEXT  NOT NULL DEFAULT 'ok',
+	ADD COLUMN avg_speed_status    TEXT  NOT NULL DEFAULT 'ok',
+	ADD COLUMN max_speed_status    TEXT  NOT NULL DEFAULT 'ok',
+	ADD COLUMN loss_text           TEXT  NOT NULL DEFAULT '',
+	ADD COLUMN ping_text           TEXT  NOT NULL DEFAULT '',
+	ADD COLUMN google_ping_text    TEXT  NOT NULL DEFAULT '',
+	ADD COLUMN avg_speed_text      TEXT  NOT NULL DEFAULT '',
+	ADD COLUMN max_speed_text      TEXT  NOT NULL DEFAULT '';
+`
+
+var pgTablesSQL = `
+SELECT COUNT(*) FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_name =?;
+`
+
+// IsPostgres reports whether dsn is a PostgreSQL connection URL.
+func IsPostgres(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+func openPostgres(dsn string) (*sqlStore, error) {
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: db, tablesSQL: pgTablesSQL, rowOrder: "id"}, nil
+}
+
+// openPostgresReadOnly connects to PostgreSQL with transactions that are
+// read-only by default. lib/pq sends the parameters it doesn't know to the
+// server.
+func openPostgresReadOnly(dsn string) (*sqlStore, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("default_transaction_read_only", "on")
+	u.RawQuery = q.Encode()
+	return openPostgres(u.String())
+}
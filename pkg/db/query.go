@@ -0,0 +1,211 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// latestJoin keeps the measurements of the latest image of each provider.
+var latestJoin = `
+JOIN (
+	SELECT provider_id, image_index, MAX(timestamp) AS timestamp
+	FROM images
+	GROUP BY provider_id, image_index
+) latest ON
+	latest.provider_id = i.provider_id AND
+	latest.image_index = i.image_index AND
+	latest.timestamp = i.timestamp`
+
+// latestSQL selects the measurements of the latest image of each provider.
+// Parameters are cast, so PostgreSQL knows their types. Rows without an
+// AvgSpeed only match if there is no minimum.
+var latestSQL = `
+SELECT ` + columns + joins + latestJoin + `
+WHERE
+	(CAST(? AS TEXT) = '' OR p.net_provider = ?) AND
+	lower(p.name) LIKE '%' || lower(CAST(? AS TEXT)) || '%' ESCAPE '\' AND
+	lower(n.remarks) LIKE '%' || lower(CAST(? AS TEXT)) || '%' ESCAPE '\' AND
+	(CAST(? AS TEXT) = '' OR lower(m.udp_nat_type) = lower(CAST(? AS TEXT))) AND
+	(CAST(? AS DOUBLE PRECISION) <= 0 OR m.avg_speed >= ?)
+ORDER BY
+	p.net_provider, p.name, i.image_index, m.position;
+`
+
+var providersSQL = `
+SELECT p.net_provider, p.name AS provider, i.image_index, i.timestamp
+FROM images i
+JOIN providers p ON p.id = i.provider_id;
+`
+
+// timeRange matches the images from a time up to another, which are NULL
+// for open ends. The bounds are in UTC like the stored timestamps, which
+// SQLite compares as text.
+var timeRange = `
+	(CAST(? AS TIMESTAMP) IS NULL OR i.timestamp >= ?) AND
+	(CAST(? AS TIMESTAMP) IS NULL OR i.timestamp < ?)`
+
+var historySQL = `
+SELECT ` + columns + joins + `
+WHERE
+	p.net_provider =? AND p.name =? AND n.remarks =? AND` + timeRange + `
+ORDER BY
+	i.timestamp, m.position;
+`
+
+// exportWhere filters and sorts the rows of exportSQL and exportLatestSQL.
+var exportWhere = `
+WHERE
+	(CAST(? AS TEXT) = '' OR p.net_provider = ?) AND
+	lower(p.name) LIKE '%' || lower(CAST(? AS TEXT)) || '%' ESCAPE '\' AND` + timeRange + `
+ORDER BY
+	p.net_provider, p.name, i.image_index, i.timestamp, m.position;
+`
+
+var exportSQL = `SELECT ` + columns + joins + exportWhere
+
+var exportLatestSQL = `SELECT ` + columns + joins + latestJoin + exportWhere
+
+// Filter selects rows for the read-only queries. Zero values match all rows.
+type Filter struct {
+	NetProvider string
+	Provider    string  // case-insensitive substring of the provider name
+	Remarks     string  // case-insensitive substring of the node name
+	UDPNATType  string  // case-insensitive NAT type, e.g. "Full Cone"
+	MinSpeed    float64 // minimum AvgSpeed in bytes/s
+}
+
+// ExportFilter selects the rows to export. Zero values match all rows.
+type ExportFilter struct {
+	NetProvider string
+	Provider    string    // case-insensitive substring of the provider name
+	From        time.Time // earliest timestamp
+	To          time.Time // timestamps before this
+	LatestOnly  bool      // only the latest results of each image
+}
+
+// Provider summarizes the results stored for a provider.
+type Provider struct {
+	NetProvider string    `json:"net_provider"`
+	Provider    string    `json:"provider"`
+	Images      int       `json:"images"`
+	Snapshots   int       `json:"snapshots"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// ParseTime parses a bound of a time range, e.g. of ExportFilter: an RFC
+// 3339 time, or a date. Dates are in UTC, as the times shown in the images
+// are stored as if they were. A date as the end of a range is the midnight
+// after it, so that the whole day is included. Empty strings are the zero
+// time, which leaves that end open.
+func ParseTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err == nil && end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, err
+}
+
+// escapeLike escapes the wildcards of LIKE, so that user input only matches
+// itself.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *sqlStore) Providers() ([]Provider, error) {
+	var snapshots []Row
+	if err := s.db.Select(&snapshots, providersSQL); err != nil {
+		return nil, err
+	}
+
+	type key struct{ netProvider, provider string }
+	byKey := make(map[key]*Provider)
+	images := make(map[key]map[int]bool)
+	var providers []*Provider
+	for _, snap := range snapshots {
+		k := key{snap.NetProvider, snap.Provider}
+		p, ok := byKey[k]
+		if !ok {
+			p = &Provider{NetProvider: snap.NetProvider, Provider: snap.Provider}
+			byKey[k] = p
+			images[k] = make(map[int]bool)
+			providers = append(providers, p)
+		}
+		images[k][snap.ImageIndex] = true
+		p.Images = len(images[k])
+		p.Snapshots++
+		if snap.Timestamp.After(p.LastUpdated) {
+			p.LastUpdated = snap.Timestamp
+		}
+	}
+
+	res := make([]Provider, len(providers))
+	for i, p := range providers {
+		res[i] = *p
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].NetProvider != res[j].NetProvider {
+			return res[i].NetProvider < res[j].NetProvider
+		}
+		return res[i].Provider < res[j].Provider
+	})
+	return res, nil
+}
+
+func (s *sqlStore) Latest(f Filter) ([]Row, error) {
+	var rows []Row
+	err := s.db.Select(&rows, s.db.Rebind(latestSQL),
+		f.NetProvider, f.NetProvider, escapeLike(f.Provider), escapeLike(f.Remarks),
+		f.UDPNATType, f.UDPNATType, f.MinSpeed, f.MinSpeed)
+	return rows, err
+}
+
+// timeArgs returns the arguments of timeRange.
+func timeArgs(from time.Time, to time.Time) []interface{} {
+	return []interface{}{timeArg(from), timeArg(from), timeArg(to), timeArg(to)}
+}
+
+// timeArg returns t in UTC, or nil for the zero time.
+func timeArg(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+func (s *sqlStore) History(netProvider string, provider string, remarks string, from time.Time, to time.Time) ([]Row, error) {
+	var rows []Row
+	args := append([]interface{}{netProvider, provider, remarks}, timeArgs(from, to)...)
+	err := s.db.Select(&rows, s.db.Rebind(historySQL), args...)
+	return rows, err
+}
+
+func (s *sqlStore) Export(f ExportFilter, fn func(Row) error) error {
+	query := exportSQL
+	if f.LatestOnly {
+		query = exportLatestSQL
+	}
+	args := append([]interface{}{f.NetProvider, f.NetProvider, escapeLike(f.Provider)}, timeArgs(f.From, f.To)...)
+	rows, err := s.db.Queryx(s.db.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Row
+		if err := rows.StructScan(&r); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
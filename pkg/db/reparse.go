@@ -0,0 +1,89 @@
+package db
+
+import "github.com/y1zhou/goduyaoss/pkg/parse"
+
+// reparseSQL selects the measurements of the matching providers that have
+// the OCR text of any number.
+var reparseSQL = `
+SELECT
+	m.id, m.loss, m.ping, m.google_ping, m.avg_speed, m.max_speed,
+	m.loss_status, m.ping_status, m.google_ping_status,
+	m.avg_speed_status, m.max_speed_status,
+	m.loss_text, m.ping_text, m.google_ping_text,
+	m.avg_speed_text, m.max_speed_text
+FROM measurements m
+JOIN images i ON i.id = m.image_id
+JOIN providers p ON p.id = i.provider_id
+WHERE
+	(CAST(? AS TEXT) = '' OR p.net_provider = ?) AND
+	lower(p.name) LIKE '%' || lower(CAST(? AS TEXT)) || '%' ESCAPE '\' AND
+	(m.loss_text <> '' OR m.ping_text <> '' OR m.google_ping_text <> '' OR
+	 m.avg_speed_text <> '' OR m.max_speed_text <> '')
+ORDER BY m.id;
+`
+
+var updateValuesSQL = `
+UPDATE measurements SET
+	loss =?, ping =?, google_ping =?, avg_speed =?, max_speed =?,
+	loss_status =?, ping_status =?, google_ping_status =?,
+	avg_speed_status =?, max_speed_status =?
+WHERE id =?;
+`
+
+func (s *sqlStore) Reparse(netProvider string, provider string) (int, int, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var rows []struct {
+		ID int64 `db:"id"`
+		Row
+	}
+	err = tx.Select(&rows, tx.Rebind(reparseSQL), netProvider, netProvider, escapeLike(provider))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	changed := 0
+	for _, r := range rows {
+		if !reparseRow(&r.Row) {
+			continue
+		}
+		_, err := tx.Exec(tx.Rebind(updateValuesSQL),
+			r.Loss, r.Ping, r.GooglePing, r.AvgSpeed, r.MaxSpeed,
+			r.LossStatus, r.PingStatus, r.GooglePingStatus, r.AvgSpeedStatus, r.MaxSpeedStatus,
+			r.ID)
+		if err != nil {
+			return 0, 0, err
+		}
+		changed++
+	}
+	return len(rows), changed, tx.Commit()
+}
+
+// reparseRow parses the text of the numbers of a row again, and reports
+// whether any of them changed.
+func reparseRow(r *Row) bool {
+	changed := reparseCell(&r.Loss, &r.LossStatus, r.LossText, parse.Loss)
+	changed = reparseCell(&r.Ping, &r.PingStatus, r.PingText, parse.Ping) || changed
+	changed = reparseCell(&r.GooglePing, &r.GooglePingStatus, r.GooglePingText, parse.Ping) || changed
+	changed = reparseCell(&r.AvgSpeed, &r.AvgSpeedStatus, r.AvgSpeedText, parse.Speed) || changed
+	changed = reparseCell(&r.MaxSpeed, &r.MaxSpeedStatus, r.MaxSpeedText, parse.Speed) || changed
+	return changed
+}
+
+// reparseCell parses text into value and status, and reports whether they
+// changed. Cells without text are left alone, as they were either saved
+// before the text was kept or had no such column.
+func reparseCell(value **float64, status *string, text string, fn func(string) (parse.Number, error)) bool {
+	if text == "" {
+		return false
+	}
+	v, st := parseCell(text, fn)
+	same := st == *status &&
+		(v == nil && *value == nil || v != nil && *value != nil && *v == **value)
+	*value, *status = v, st
+	return !same
+}
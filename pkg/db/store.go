@@ -0,0 +1,295 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/y1zhou/goduyaoss/pkg/metrics"
+)
+
+// Store saves and queries the results. Open returns the implementation
+// for a data source name.
+type Store interface {
+	// StartRun records the start of a command that saves results, and
+	// returns its ID for Snapshot.RunID.
+	StartRun(command string) (int64, error)
+	// FinishRun records the end of a run.
+	FinishRun(id int64) error
+	// InsertSnapshot adds the rows of a result image. It returns ErrExists
+	// and changes nothing if the image was saved before, so two workers
+	// never save the same results twice.
+	InsertSnapshot(s Snapshot) error
+	// ReplaceSnapshot adds the rows of a result image, or replaces the rows
+	// of the image with the same timestamp, e.g. when images are processed
+	// again after the OCR is improved.
+	ReplaceSnapshot(s Snapshot) error
+	// LatestTime returns the timestamp of the latest results of an image
+	// of a provider, or the zero time if there are none.
+	LatestTime(netProvider string, provider string, imageIndex int) (time.Time, error)
+	// Latest returns the rows of the latest results of each provider that
+	// match the filter.
+	Latest(f Filter) ([]Row, error)
+	// History returns the results of a node from from up to, but not
+	// including, to, oldest first. A zero from or to leaves that end of the
+	// range open.
+	History(netProvider string, provider string, remarks string, from time.Time, to time.Time) ([]Row, error)
+	// Export calls fn with each row that matches the filter, sorted by
+	// provider, image and time. The rows are read one at a time, so fn must
+	// not use the store. An error from fn stops the export and is returned.
+	Export(f ExportFilter, fn func(Row) error) error
+	// Providers returns the providers with results, sorted by net provider
+	// and name.
+	Providers() ([]Provider, error)
+	// Reparse parses the OCR text of the measurements of the providers that
+	// match again, e.g. after a parsing bug is fixed, and saves the numbers
+	// that changed. provider matches parts of the names like Filter.
+	// Measurements saved before the text was kept are skipped. It returns
+	// the number of measurements that were read and changed.
+	Reparse(netProvider string, provider string) (int, int, error)
+	Close() error
+}
+
+// ErrExists is returned by InsertSnapshot if the image was saved before.
+var ErrExists = errors.New("results of the image were saved before")
+
+// Snapshot is the table read from a result image.
+type Snapshot struct {
+	NetProvider string
+	Provider    string
+	ImageIndex  int
+	Timestamp   time.Time
+	Table       [][]string // columns of the table, see ocr.GetHeader
+	RunID       int64      // run that read the image, 0 if unknown
+	URL         string     // where the image was downloaded from
+	SHA256      string     // hash of the image in the archive
+}
+
+// Open connects to the database. The tables are created in a new database,
+// but an existing one must be up to date, see Migrate. dsn is a postgres://
+// URL for PostgreSQL, or else the path to a SQLite database.
+func Open(dsn string) (Store, error) {
+	s, err := connect(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkVersion(true); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenReadOnly connects to an existing database for commands that only
+// read it. Unlike Open, a missing or empty database is an error instead of
+// being set up, so that a mistyped path isn't served as having no results.
+// Writes through the store fail.
+func OpenReadOnly(dsn string) (Store, error) {
+	s, err := connectReadOnly(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkVersion(false); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func connect(dsn string) (*sqlStore, error) {
+	if IsPostgres(dsn) {
+		return openPostgres(dsn)
+	}
+	return openSQLite(dsn)
+}
+
+// connectReadOnly connects to an existing database without creating it.
+func connectReadOnly(dsn string) (*sqlStore, error) {
+	if IsPostgres(dsn) {
+		return openPostgresReadOnly(dsn)
+	}
+	return openSQLiteReadOnly(dsn)
+}
+
+// sqlStore implements Store for the databases supported by sqlx. The SQL is
+// written with ? placeholders and rebound for each database.
+type sqlStore struct {
+	db *sqlx.DB
+	// tablesSQL counts the tables with a name
+	tablesSQL string
+	// rowOrder is the column that keeps the rows of the legacy table in
+	// order
+	rowOrder string
+}
+
+var sqliteTablesSQL = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name =?;`
+
+func openSQLite(path string) (*sqlStore, error) {
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, so the workers take turns instead of
+	// failing with "database is locked"
+	db.SetMaxOpenConns(1)
+	return &sqlStore{db: db, tablesSQL: sqliteTablesSQL, rowOrder: "rowid"}, nil
+}
+
+// openSQLiteReadOnly opens an existing SQLite database in read-only mode,
+// which SQLite would otherwise create.
+func openSQLiteReadOnly(path string) (*sqlStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return openSQLite("file:" + uriEscaper.Replace(path) + "?mode=ro")
+}
+
+// uriEscaper escapes the characters of a path that are special in SQLite
+// URI filenames.
+var uriEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+func (s *sqlStore) postgres() bool {
+	return s.db.DriverName() == "postgres"
+}
+
+func (s *sqlStore) StartRun(command string) (int64, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	id, err := s.insertID(tx, insertRunSQL, command, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *sqlStore) FinishRun(id int64) error {
+	_, err := s.db.Exec(s.db.Rebind(finishRunSQL), time.Now(), id)
+	return err
+}
+
+func (s *sqlStore) InsertSnapshot(snap Snapshot) error {
+	return s.insert(snap, tableRows(snap), false)
+}
+
+func (s *sqlStore) ReplaceSnapshot(snap Snapshot) error {
+	return s.insert(snap, tableRows(snap), true)
+}
+
+// insert saves the rows of a snapshot in a transaction, so the check for an
+// existing image and the insert are atomic. The rows of an existing image
+// are removed first if replace is set.
+func (s *sqlStore) insert(snap Snapshot, rows []Row, replace bool) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := s.insertTx(tx, snap, rows, replace); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	metrics.RowsInserted.Add(float64(len(rows)))
+	return nil
+}
+
+func (s *sqlStore) insertTx(tx *sqlx.Tx, snap Snapshot, rows []Row, replace bool) error {
+	providerID, _, err := upsertID(tx,
+		upsertProviderSQL, []interface{}{snap.NetProvider, snap.Provider},
+		selectProviderSQL, snap.NetProvider, snap.Provider)
+	if err != nil {
+		return err
+	}
+
+	var runID interface{}
+	if snap.RunID != 0 {
+		runID = snap.RunID
+	}
+	insert := addImageSQL
+	if replace {
+		insert = upsertImageSQL
+	}
+	imageID, changed, err := upsertID(tx,
+		insert, []interface{}{providerID, runID, snap.ImageIndex, snap.Timestamp, snap.URL, snap.SHA256},
+		selectImageSQL, providerID, snap.ImageIndex, snap.Timestamp)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return ErrExists
+	}
+	if replace {
+		if _, err := tx.Exec(tx.Rebind(deleteMeasurementsSQL), imageID); err != nil {
+			return err
+		}
+	}
+
+	for i, r := range rows {
+		nodeID, _, err := upsertID(tx,
+			upsertNodeSQL, []interface{}{providerID, r.Remarks, r.Group},
+			selectNodeIDSQL, providerID, r.Remarks)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind(upsertMeasurementSQL), imageID, nodeID, i,
+			r.Loss, r.Ping, r.GooglePing, r.AvgSpeed, r.MaxSpeed, r.UDPNATType,
+			r.LossStatus, r.PingStatus, r.GooglePingStatus, r.AvgSpeedStatus, r.MaxSpeedStatus,
+			r.LossText, r.PingText, r.GooglePingText, r.AvgSpeedText, r.MaxSpeedText)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertID runs an INSERT ... ON CONFLICT, then returns the ID of the row
+// with the same key, and whether the INSERT added or updated it.
+func upsertID(tx *sqlx.Tx, upsert string, values []interface{}, query string, key ...interface{}) (int64, bool, error) {
+	res, err := tx.Exec(tx.Rebind(upsert), values...)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	var id int64
+	err = tx.Get(&id, tx.Rebind(query), key...)
+	return id, n > 0, err
+}
+
+// insertID runs an INSERT and returns the ID of the new row.
+func (s *sqlStore) insertID(tx *sqlx.Tx, query string, args ...interface{}) (int64, error) {
+	query = tx.Rebind(query)
+	// PostgreSQL doesn't support LastInsertId
+	if s.postgres() {
+		var id int64
+		err := tx.QueryRowx(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *sqlStore) LatestTime(netProvider string, provider string, imageIndex int) (time.Time, error) {
+	var timestamp time.Time
+	err := s.db.Get(&timestamp, s.db.Rebind(querySQL), netProvider, provider, imageIndex)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return timestamp, err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,184 @@
+// Package export writes rows of results in formats for data analysis: CSV,
+// JSON Lines and Parquet.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+// Formats are the names of the formats that New accepts.
+var Formats = []string{"csv", "ndjson", "parquet"}
+
+// Writer writes rows one at a time. Close must be called after the last
+// row to flush the output, but doesn't close the underlying writer.
+type Writer interface {
+	Write(r db.Row) error
+	Close() error
+}
+
+// New returns a Writer of a format to w.
+func New(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "csv":
+		return NewCSV(w), nil
+	case "ndjson":
+		return NewNDJSON(w), nil
+	case "parquet":
+		return NewParquet(w)
+	}
+	return nil, fmt.Errorf("unknown format %q (should be one of %v)", format, Formats)
+}
+
+// Header is the first row of CSV files, which are the names of the fields
+// in all formats.
+var Header = []string{
+	"net_provider", "provider", "image_index", "timestamp", "group", "remarks",
+	"loss", "ping", "google_ping", "avg_speed", "max_speed", "udp_nat_type",
+	"loss_status", "ping_status", "google_ping_status", "avg_speed_status", "max_speed_status",
+	"loss_text", "ping_text", "google_ping_text", "avg_speed_text", "max_speed_text",
+	"sha256",
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// NewCSV returns a Writer of CSV with Header. Timestamps are RFC 3339, and
+// numbers that weren't read are empty.
+func NewCSV(w io.Writer) Writer {
+	c := &csvWriter{w: csv.NewWriter(w)}
+	// errors of the buffered writer are returned by Close
+	c.w.Write(Header)
+	return c
+}
+
+func (c *csvWriter) Write(r db.Row) error {
+	return c.w.Write([]string{
+		r.NetProvider, r.Provider, strconv.Itoa(r.ImageIndex),
+		r.Timestamp.Format(time.RFC3339), r.Group, r.Remarks,
+		FormatFloat(r.Loss), FormatFloat(r.Ping), FormatFloat(r.GooglePing),
+		FormatFloat(r.AvgSpeed), FormatFloat(r.MaxSpeed), r.UDPNATType,
+		r.LossStatus, r.PingStatus, r.GooglePingStatus, r.AvgSpeedStatus, r.MaxSpeedStatus,
+		r.LossText, r.PingText, r.GooglePingText, r.AvgSpeedText, r.MaxSpeedText,
+		r.SHA256,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// FormatFloat formats a number of a row, or returns an empty cell for
+// numbers that weren't read.
+func FormatFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+// NewNDJSON returns a Writer of JSON Lines, with a JSON object per row like
+// the API.
+func NewNDJSON(w io.Writer) Writer {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *ndjsonWriter) Write(r db.Row) error {
+	return n.enc.Encode(r)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// parquetRow is a row in Parquet files. Timestamps are in microseconds
+// since the epoch in UTC.
+type parquetRow struct {
+	NetProvider      string   `parquet:"name=net_provider, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Provider         string   `parquet:"name=provider, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ImageIndex       int32    `parquet:"name=image_index, type=INT32"`
+	Timestamp        int64    `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Group            string   `parquet:"name=group, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Remarks          string   `parquet:"name=remarks, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Loss             *float64 `parquet:"name=loss, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Ping             *float64 `parquet:"name=ping, type=DOUBLE, repetitiontype=OPTIONAL"`
+	GooglePing       *float64 `parquet:"name=google_ping, type=DOUBLE, repetitiontype=OPTIONAL"`
+	AvgSpeed         *float64 `parquet:"name=avg_speed, type=DOUBLE, repetitiontype=OPTIONAL"`
+	MaxSpeed         *float64 `parquet:"name=max_speed, type=DOUBLE, repetitiontype=OPTIONAL"`
+	UDPNATType       string   `parquet:"name=udp_nat_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LossStatus       string   `parquet:"name=loss_status, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PingStatus       string   `parquet:"name=ping_status, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	GooglePingStatus string   `parquet:"name=google_ping_status, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	AvgSpeedStatus   string   `parquet:"name=avg_speed_status, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	MaxSpeedStatus   string   `parquet:"name=max_speed_status, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LossText         string   `parquet:"name=loss_text, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PingText         string   `parquet:"name=ping_text, type=BYTE_ARRAY, convertedtype=UTF8"`
+	GooglePingText   string   `parquet:"name=google_ping_text, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AvgSpeedText     string   `parquet:"name=avg_speed_text, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MaxSpeedText     string   `parquet:"name=max_speed_text, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SHA256           string   `parquet:"name=sha256, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// rowGroupSize limits the rows that are kept in memory before they're
+// written, instead of the 128 MB default.
+const rowGroupSize = 16 * 1024 * 1024
+
+type parquetWriter struct {
+	pw *writer.ParquetWriter
+}
+
+// NewParquet returns a Writer of a Parquet file, compressed with Snappy.
+// The file is written in row groups, and its footer by Close.
+func NewParquet(w io.Writer) (Writer, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = rowGroupSize
+	return &parquetWriter{pw: pw}, nil
+}
+
+func (p *parquetWriter) Write(r db.Row) error {
+	return p.pw.Write(parquetRow{
+		NetProvider:      r.NetProvider,
+		Provider:         r.Provider,
+		ImageIndex:       int32(r.ImageIndex),
+		Timestamp:        r.Timestamp.UnixNano() / int64(time.Microsecond),
+		Group:            r.Group,
+		Remarks:          r.Remarks,
+		Loss:             r.Loss,
+		Ping:             r.Ping,
+		GooglePing:       r.GooglePing,
+		AvgSpeed:         r.AvgSpeed,
+		MaxSpeed:         r.MaxSpeed,
+		UDPNATType:       r.UDPNATType,
+		LossStatus:       r.LossStatus,
+		PingStatus:       r.PingStatus,
+		GooglePingStatus: r.GooglePingStatus,
+		AvgSpeedStatus:   r.AvgSpeedStatus,
+		MaxSpeedStatus:   r.MaxSpeedStatus,
+		LossText:         r.LossText,
+		PingText:         r.PingText,
+		GooglePingText:   r.GooglePingText,
+		AvgSpeedText:     r.AvgSpeedText,
+		MaxSpeedText:     r.MaxSpeedText,
+		SHA256:           r.SHA256,
+	})
+}
+
+func (p *parquetWriter) Close() error {
+	return p.pw.WriteStop()
+}
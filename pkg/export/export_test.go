@@ -0,0 +1,119 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+var timestamp = time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+var rows = []db.Row{
+	{
+		NetProvider: "联通", Provider: "ssrcloud", Timestamp: timestamp, Group: "Group", Remarks: "HK 01",
+		Loss: float(0), Ping: float(52.13), GooglePing: float(144.52), AvgSpeed: float(21.48e6),
+		UDPNATType: "Full Cone", LossStatus: db.StatusOK, PingStatus: db.StatusOK,
+		GooglePingStatus: db.StatusOK, AvgSpeedStatus: db.StatusOK, MaxSpeedStatus: db.StatusNotTested,
+		LossText: "0.00%", PingText: "52.13", GooglePingText: "144.52", AvgSpeedText: "21.48MB",
+		SHA256: "abc",
+	},
+	{
+		NetProvider: "联通", Provider: "ssrcloud", Timestamp: timestamp, Group: "Group", Remarks: "US 01",
+		LossStatus: db.StatusNotTested, PingStatus: db.StatusNotTested, GooglePingStatus: db.StatusNotTested,
+		AvgSpeedStatus: db.StatusUnparseable, MaxSpeedStatus: db.StatusNotTested,
+		LossText: "N/A", PingText: "N/A", GooglePingText: "N/A", AvgSpeedText: "2l.4",
+	},
+}
+
+func write(t *testing.T, format string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := New(format, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(write(t, "csv"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(Header, ",") {
+		t.Fatalf("CSV is %q", records)
+	}
+	if r := records[1]; r[3] != "2020-12-11T20:30:03Z" || r[9] != "21480000" || r[10] != "" || r[18] != "52.13" || r[22] != "abc" {
+		t.Errorf("First CSV row is %q", r)
+	}
+	if r := records[2]; r[6] != "" || r[15] != db.StatusUnparseable || r[20] != "2l.4" {
+		t.Errorf("Second CSV row is %q", r)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(write(t, "ndjson"))), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("JSON Lines are %q", lines)
+	}
+	var r db.Row
+	if err := json.Unmarshal([]byte(lines[1]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Remarks != "US 01" || r.Loss != nil || r.AvgSpeedText != "2l.4" || !r.Timestamp.Equal(timestamp) {
+		t.Errorf("Second row is %+v", r)
+	}
+}
+
+func TestParquet(t *testing.T) {
+	f, err := buffer.NewBufferFile(write(t, "parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(f, new(parquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	res := make([]parquetRow, pr.GetNumRows())
+	if err := pr.Read(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("Parquet rows are %+v", res)
+	}
+	r := res[0]
+	if r.Remarks != "HK 01" || r.AvgSpeed == nil || *r.AvgSpeed != 21.48e6 || r.MaxSpeed != nil || r.SHA256 != "abc" ||
+		time.Unix(0, r.Timestamp*int64(time.Microsecond)).UTC() != timestamp {
+		t.Errorf("First Parquet row is %+v", r)
+	}
+	if r := res[1]; r.Loss != nil || r.AvgSpeedStatus != db.StatusUnparseable {
+		t.Errorf("Second Parquet row is %+v", r)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := New("xlsx", new(bytes.Buffer)); err == nil {
+		t.Error("Created a writer of an unknown format")
+	}
+}
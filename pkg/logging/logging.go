@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// debugLogger writes like the standard logger, but always includes debug
+// messages. It's used for the jobs that have debug output turned on.
+var debugLogger = logrus.New()
+
+func init() {
+	Setup("info", "json")
+}
+
+// Setup configures the standard logrus logger. The level is one of the
+// logrus levels, e.g. "info" or "debug", and the format is "json" or "text".
+func Setup(level string, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	var formatter logrus.Formatter
+	switch format {
+	case "json":
+		formatter = &logrus.JSONFormatter{}
+	case "text":
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	default:
+		return fmt.Errorf("unknown log format %q (should be json or text)", format)
+	}
+
+	std := logrus.StandardLogger()
+	std.SetLevel(lvl)
+	std.SetFormatter(formatter)
+	debugLogger.SetOutput(std.Out)
+	debugLogger.SetFormatter(formatter)
+	debugLogger.SetLevel(logrus.DebugLevel)
+	if lvl > logrus.DebugLevel {
+		debugLogger.SetLevel(lvl)
+	}
+	return nil
+}
+
+// Logger returns the standard logger, or one that includes debug messages
+// if debug is true.
+func Logger(debug bool) *logrus.Logger {
+	if debug {
+		return debugLogger
+	}
+	return logrus.StandardLogger()
+}
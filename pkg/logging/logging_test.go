@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogger(t *testing.T) {
+	if err := Setup("verbose", "json"); err == nil {
+		t.Error("Invalid level was accepted")
+	}
+	if err := Setup("info", "xml"); err == nil {
+		t.Error("Invalid format was accepted")
+	}
+	if err := Setup("info", "json"); err != nil {
+		t.Fatal(err)
+	}
+	defer Setup("info", "json")
+
+	buf := new(bytes.Buffer)
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(debugLogger.Out)
+	Setup("info", "json")
+
+	Logger(false).WithField("job_id", 1).Debug("hidden")
+	Logger(true).WithField("job_id", 2).Debug("shown")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Log output %q is not a single JSON record: %s", buf, err)
+	}
+	if record["msg"] != "shown" || record["job_id"] != 2.0 || record["level"] != "debug" {
+		t.Errorf("Log record is %v", record)
+	}
+}
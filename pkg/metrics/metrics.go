@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "goduyaoss"
+
+// Stages of the pipeline for the Failures label.
+const (
+	StagePage    = "page"    // downloading an article
+	StageParse   = "parse"   // finding the providers in an article
+	StageImage   = "image"   // downloading or decoding a result image
+	StageArchive = "archive" // saving an image to the archive
+	StageOCR     = "ocr"     // reading the table of an image
+	StageDB      = "db"      // querying or saving the results
+	StageRun     = "run"     // starting a crawl, e.g. opening the database
+)
+
+var (
+	// PagesFetched counts the articles that were downloaded or served from
+	// the cache because they didn't change.
+	PagesFetched = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pages_fetched_total",
+		Help:      "Number of articles fetched.",
+	})
+
+	// ImageBytes counts the size of the new or changed images.
+	ImageBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_download_bytes_total",
+		Help:      "Bytes of result images downloaded.",
+	})
+
+	// ImageDuration is the time taken to download and decode new or changed
+	// images. Its count is the number of images downloaded.
+	ImageDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_download_duration_seconds",
+		Help:      "Time taken to download and decode a result image.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	// ImagesUnchanged counts the images skipped because the cached copy is
+	// still current.
+	ImagesUnchanged = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "images_unchanged_total",
+		Help:      "Number of result images skipped because they didn't change.",
+	})
+
+	// JobsQueued counts the images sent to the OCR workers.
+	JobsQueued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ocr_jobs_queued_total",
+		Help:      "Number of OCR jobs added to the queue.",
+	})
+
+	// JobsProcessed counts the images whose table was read and saved.
+	JobsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ocr_jobs_processed_total",
+		Help:      "Number of OCR jobs whose results were saved.",
+	})
+
+	// JobsSkipped counts the images that are older than the saved results.
+	JobsSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ocr_jobs_skipped_total",
+		Help:      "Number of OCR jobs skipped because the results are up to date.",
+	})
+
+	// CellFallbacks counts the columns that were read cell by cell, because
+	// reading the whole column gave the wrong number of rows.
+	CellFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ocr_cell_fallback_total",
+		Help:      "Number of columns read cell by cell after column mode returned the wrong number of rows.",
+	}, []string{"column"})
+
+	// RowsInserted counts the rows written to the database.
+	RowsInserted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_rows_inserted_total",
+		Help:      "Number of result rows inserted into the database.",
+	})
+
+	// Failures counts the pages, images and jobs that were skipped because
+	// of an error, by the stage of the pipeline.
+	Failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failures_total",
+		Help:      "Number of errors by pipeline stage.",
+	}, []string{"stage"})
+
+	// LastCrawl is the time the last crawl finished.
+	LastCrawl = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "crawl_last_finished_timestamp_seconds",
+		Help:      "Unix time the last crawl finished.",
+	})
+)
+
+func init() {
+	// Export the stages before the first failure, so that rate() works
+	for _, stage := range []string{StagePage, StageParse, StageImage, StageArchive, StageOCR, StageDB, StageRun} {
+		Failures.WithLabelValues(stage)
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	RowsInserted.Add(3)
+	CellFallbacks.WithLabelValues("remarks").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		"goduyaoss_db_rows_inserted_total 3",
+		`goduyaoss_ocr_cell_fallback_total{column="remarks"} 1`,
+		`goduyaoss_failures_total{stage="image"} 0`,
+		"goduyaoss_image_download_duration_seconds_bucket",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metrics don't contain %q", want)
+		}
+	}
+}
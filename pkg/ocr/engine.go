@@ -0,0 +1,53 @@
+package ocr
+
+import "image"
+
+// Mode is the layout of the text in a region.
+type Mode int
+
+const (
+	// Line is a single line of text, e.g. a cell.
+	Line Mode = iota
+	// Block is a block of lines, e.g. a column of cells.
+	Block
+)
+
+// Hints describe the text in a region, to help the engine read it.
+type Hints struct {
+	Languages []string // languages of the text, e.g. "eng" and "chi_sim"
+	Whitelist string   // characters that may appear, or any if empty
+	Mode      Mode
+}
+
+// Engine reads the text in regions of images. An Engine is used by one
+// goroutine at a time, so each worker has its own. The engine of Tesseract
+// is in the tesseract subpackage.
+type Engine interface {
+	// Recognize returns the text in img, a region of the table whose bounds
+	// are its position in the table.
+	Recognize(img image.Image, hints Hints) (string, error)
+	Close() error
+}
+
+// FakeEngine is a deterministic Engine for tests without Tesseract. The
+// text of a region is returned by Text, and the image isn't read.
+type FakeEngine struct {
+	Text func(region image.Rectangle, hints Hints) string
+	// Calls are the regions and hints passed to Recognize, in order
+	Calls []FakeCall
+}
+
+// FakeCall is a call of FakeEngine.Recognize.
+type FakeCall struct {
+	Region image.Rectangle
+	Hints  Hints
+}
+
+func (f *FakeEngine) Recognize(img image.Image, hints Hints) (string, error) {
+	f.Calls = append(f.Calls, FakeCall{Region: img.Bounds(), Hints: hints})
+	return f.Text(img.Bounds(), hints), nil
+}
+
+func (f *FakeEngine) Close() error {
+	return nil
+}
@@ -1,12 +1,14 @@
 package ocr
 
 import (
-	"log"
+	"fmt"
+	"image"
 	"regexp"
 	"strings"
 	"time"
 
-	"github.com/otiai10/gosseract"
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/metrics"
 	"gocv.io/x/gocv"
 )
 
@@ -25,75 +27,82 @@ var charWhitelist = map[string]string{
 	"udp_nat_type": "- ABDFNOPRSTUacdeiklmnoprstuwy", // See https://github.com/arantonitis/pynat/blob/c5fe553bbbb79deecedcce83c4d4d2974b139355/pynat.py#L51-L59
 }
 
-func fileOCR(imgPath string, client *gosseract.Client) string {
-	if err := client.SetImage(imgPath); err != nil {
-		log.Fatal(err)
+// SetCharWhitelist replaces the whitelists of the given columns, e.g. from
+// the config file. It must be called before any OCR is run.
+func SetCharWhitelist(whitelist map[string]string) error {
+	for key := range whitelist {
+		if _, ok := charWhitelist[key]; !ok {
+			return fmt.Errorf("unknown column %q in character whitelist", key)
+		}
 	}
-	text, _ := client.Text()
-
-	return text
-}
-
-func imgOCR(imgMat gocv.Mat, client *gosseract.Client) string {
-	// Mat -> image.Image
-	imgByte, err := gocv.IMEncode(gocv.PNGFileExt, imgMat)
-	if err != nil {
-		log.Fatalf("Can't convert gocv.Mat to []byte: %q", err.Error())
+	for key, chars := range whitelist {
+		charWhitelist[key] = chars
 	}
+	return nil
+}
 
-	if err := client.SetImageFromBytes(imgByte); err != nil {
-		log.Fatalf("Can't send image bytes to Tesseract: %q", err.Error())
+// recognize reads the text in a region of img with engine.
+func recognize(engine Engine, img image.Image, region image.Rectangle, hints Hints) (string, error) {
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return "", fmt.Errorf("can't crop image of type %T", img)
 	}
-	text, err := client.Text()
+	text, err := engine.Recognize(sub.SubImage(region), hints)
 	if err != nil {
-		log.Fatalf("Can't get text from image: %q", err.Error())
+		return "", fmt.Errorf("can't get text from region %v: %s", region, err)
 	}
-
-	return text
+	return text, nil
 }
 
-func configTesseract(client *gosseract.Client, whitelistKey string, engOnly bool, colMode bool) {
+// columnHints returns the hints of a column of the table, with the
+// whitelist of charWhitelist. block is set to read the whole column at once.
+func columnHints(whitelistKey string, engOnly bool, block bool) Hints {
+	hints := Hints{
+		Languages: []string{"chi_sim", "eng"},
+		Whitelist: charWhitelist[whitelistKey], // "" if key not in map
+		Mode:      Line,
+	}
 	if engOnly {
-		client.SetLanguage("eng")
-	} else {
-		client.SetLanguage("chi_sim", "eng")
+		hints.Languages = []string{"eng"}
 	}
-	if colMode {
-		client.SetPageSegMode(gosseract.PSM_AUTO)
-	} else {
-		client.SetPageSegMode(gosseract.PSM_SINGLE_LINE)
+	if block {
+		hints.Mode = Block
 	}
-
-	whitelist, _ := charWhitelist[whitelistKey]
-	client.SetWhitelist(whitelist) // sets whitelist to "" if key not in map
+	return hints
 }
 
 // GetMetadata retrieves information from the image that only need to be run once:
 // The SSRSpeed software version at the very top, and
 // the time the image was generated (timestamp in the last row).
-func GetMetadata(img gocv.Mat) time.Time {
+// The text read by engine is logged to entry at debug level. The time is
+// zero if no timestamp was found, and an error is returned if engine failed.
+func GetMetadata(engine Engine, img gocv.Mat, entry *log.Entry) (time.Time, error) {
 	// Convert to grayscale
 	imgGray := img.Clone()
 	defer imgGray.Close()
 	convertToGrayscale(imgGray)
+	gray, err := imgGray.ToImage()
+	if err != nil {
+		return time.Time{}, err
+	}
 
 	// last row is the timestamp
-	imgTimestamp := cropImage(imgGray,
-		0, imgGray.Cols()/2,
-		imgGray.Rows()-rowHeight, imgGray.Rows())
-	defer imgTimestamp.Close()
-
-	client := gosseract.NewClient()
-	defer client.Close()
-	configTesseract(client, "", true, false)
-	resTimestr := imgOCR(imgTimestamp, client)
+	region := image.Rect(0, imgGray.Rows()-rowHeight, imgGray.Cols()/2, imgGray.Rows())
+	resTimestr, err := recognize(engine, gray, region, columnHints("", true, false))
+	if err != nil {
+		return time.Time{}, err
+	}
+	entry.WithField("stage", "metadata").Debugf("Timestamp text: %q", resTimestr)
 	resTimestamp := cleanTimestamp(&resTimestr)
 
-	return resTimestamp
+	return resTimestamp, nil
 }
 
-// GetHeader returns the column names based on the number of columns.
-func GetHeader(numCols int) []string {
+// GetHeader returns the column names based on the number of columns, or
+// an error for tables of another layout.
+func GetHeader(numCols int) ([]string, error) {
 	var header = []string{"group", "remarks", "loss", "ping", "google_ping", "avg_speed"}
 	switch numCols {
 	case 6:
@@ -105,34 +114,47 @@ func GetHeader(numCols int) []string {
 		header = append(header, "max_speed", "udp_nat_type")
 		break
 	default:
-		log.Fatalf("%d columns detected (should be 6-8)", numCols)
+		return nil, fmt.Errorf("%d columns detected (should be 6-8)", numCols)
 	}
 
-	return header
+	return header, nil
 }
 
-// ImgToTable runs Tesseract on each cell and returns a parsed table.
-func ImgToTable(img gocv.Mat) [][]string {
+// ImgToTable runs OCR on each cell with engine and returns a parsed table.
+// The steps of the preprocessing are logged to entry at debug level. An
+// error is returned if the rows or columns of the table weren't found, or if
+// engine failed to read a region.
+func ImgToTable(engine Engine, img gocv.Mat, entry *log.Entry) ([][]string, error) {
+	entry = entry.WithField("stage", "ocr")
 	rows, cols := getBorderIndex(img)
 	numRows, numCols := len(rows)-1, len(cols)-1
+	entry.Debugf("Found %d rows and %d columns, row borders %v, column borders %v",
+		numRows, numCols, rows, cols)
+	// The first two and last two rows aren't results, e.g. the timestamp
+	if numRows < 4 {
+		return nil, fmt.Errorf("%d rows detected (should be at least 4)", numRows)
+	}
+	header, err := GetHeader(numCols)
+	if err != nil {
+		return nil, err
+	}
 
 	// Remove watermark and background colors
 	// removeColor(&img, cols)
 
 	// Enhance row borders
 	drawRowBorders(&img, rows)
-
-	// Header names
-	header := GetHeader(numCols)
+	tbl, err := img.ToImage()
+	if err != nil {
+		return nil, err
+	}
 
 	// Group name stays the same for all rows
-	imgGroup := cropImage(img, cols[0], cols[1], rows[2], rows[3])
-	defer imgGroup.Close()
-
-	client := gosseract.NewClient()
-
-	configTesseract(client, "", false, false)
-	txtGroup := imgOCR(imgGroup, client)
+	txtGroup, err := recognize(engine, tbl, image.Rect(cols[0], rows[2], cols[1], rows[3]), columnHints("", false, false))
+	if err != nil {
+		return nil, err
+	}
+	entry.Debugf("Group: %q", txtGroup)
 
 	// Duplicate to make first column
 	firstCol := make([]string, numRows-4)
@@ -146,34 +168,32 @@ func ImgToTable(img gocv.Mat) [][]string {
 
 	newLineRegex := regexp.MustCompile(`\n+`)
 	for j := 1; j < numCols; j++ {
+		// Only the remarks have Chinese characters
+		engOnly := j != 1
+
 		// Try to use column mode first because it's much faster
-		if j == 1 {
-			configTesseract(client, header[j], false, true)
-		} else {
-			configTesseract(client, header[j], true, true)
+		col := image.Rect(cols[j], rows[2], cols[j+1], rows[numRows-2])
+		text, err := recognize(engine, tbl, col, columnHints(header[j], engOnly, true))
+		if err != nil {
+			return nil, err
 		}
-		col := cropImage(img, cols[j], cols[j+1], rows[2], rows[numRows-2])
-		defer col.Close()
-
-		text := imgOCR(col, client)
 		text = newLineRegex.ReplaceAllString(text, "\n")
 		txtCol := strings.Split(text, "\n")
 
 		// If the number of rows is incorrect, run OCR on each cell.
 		// This is much slower but also more accurate.
 		if len(txtCol) != numRows-4 {
+			entry.Debugf("Column %s has %d rows instead of %d, reading each cell",
+				header[j], len(txtCol), numRows-4)
+			metrics.CellFallbacks.WithLabelValues(header[j]).Inc()
 			txtCol = make([]string, numRows-4)
 
 			for i := 2; i < numRows-2; i++ {
-				if j == 1 {
-					configTesseract(client, header[j], false, false)
-				} else {
-					configTesseract(client, header[j], true, false)
+				cell := image.Rect(cols[j], rows[i], cols[j+1], rows[i+1])
+				txtCol[i-2], err = recognize(engine, tbl, cell, columnHints(header[j], engOnly, false))
+				if err != nil {
+					return nil, err
 				}
-				cell := cropImage(img, cols[j], cols[j+1], rows[i], rows[i+1])
-				defer cell.Close()
-
-				txtCol[i-2] = imgOCR(cell, client)
 			}
 		}
 
@@ -183,6 +203,5 @@ func ImgToTable(img gocv.Mat) [][]string {
 
 		res[j] = txtCol
 	}
-	client.Close()
-	return res
+	return res, nil
 }
@@ -1,10 +1,13 @@
 package ocr
 
 import (
+	"errors"
+	"image"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/otiai10/gosseract"
+	log "github.com/sirupsen/logrus"
 )
 
 func TestCleanTimestamp(t *testing.T) {
@@ -36,63 +39,100 @@ func TestGetIntersections(t *testing.T) {
 	}
 }
 
-func TestFileOCR(t *testing.T) {
+func TestImgToTableFake(t *testing.T) {
 	img := readImg("testdata/sample_img.png")
 	defer img.Close()
 
-	convertToGrayscale(img)
-
-	client := gosseract.NewClient()
-	defer client.Close()
-
-	// Testcase from the "Remarks" column
-	imgName := cropImage(img, 64, 479, 60, 90)
-	defer imgName.Close()
-
-	configTesseract(client, "Remarks", false, false)
-	nodeName := imgOCR(imgName, client)
-	trueName := "*Ultimate|IEPL-BGP广新01|3.0|INF* - 1063 单端口"
-	if nodeName != trueName {
-		t.Errorf("OCR text is %q, but should be %q", nodeName, trueName)
+	// Columns are read at once, except the remarks, which have a row too
+	// few and are read by cell
+	lines := func(line string, n int) string {
+		return strings.TrimSuffix(strings.Repeat(line+"\n", n), "\n")
+	}
+	engine := &FakeEngine{Text: func(region image.Rectangle, hints Hints) string {
+		switch {
+		case hints.Mode == Line && hints.Whitelist == "":
+			return " node "
+		case hints.Mode == Block && hints.Whitelist == "":
+			return lines("node", 44)
+		case hints.Whitelist == charWhitelist["avg_speed"]:
+			// empty lines are skipped
+			return lines("21.48MB\n", 45)
+		}
+		return lines("0", 45)
+	}}
+	res, err := ImgToTable(engine, img, log.WithField("image_url", "testdata/sample_img.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 7 {
+		t.Fatalf("Should be 7 columns, found %d", len(res))
+	}
+	for i := range res {
+		if len(res[i]) != 45 {
+			t.Errorf("Should be 45 rows, found %d in column %d", len(res[i]), i)
+		}
+	}
+	if res[0][0] != " node " || res[1][44] != "node" || res[5][44] != "21.48MB" {
+		t.Errorf("Table is %q", res)
 	}
 
-	// Testcase from the "AvgSpeed" column
-	imgSpeed := cropImage(img, 807, 897, 60, 90)
-	defer imgSpeed.Close()
-
-	configTesseract(client, "AvgSpeed", true, false)
-	nodeSpeed := imgOCR(imgSpeed, client)
-	if nodeSpeed != "21.48MB" {
-		t.Errorf("OCR text is %q, but should be 21.48MB", nodeSpeed)
+	// The group, 6 columns, and the 45 cells of the remarks
+	if len(engine.Calls) != 1+6+45 {
+		t.Errorf("Recognize was called %d times", len(engine.Calls))
+	}
+	for _, c := range engine.Calls {
+		chinese := len(c.Hints.Languages) == 2
+		if chinese != (c.Hints.Whitelist == "") {
+			t.Errorf("Hints of %v are %+v", c.Region, c.Hints)
+		}
 	}
 }
 
-func TestGetMetadata(t *testing.T) {
+func TestImgToTableEngineError(t *testing.T) {
 	img := readImg("testdata/sample_img.png")
 	defer img.Close()
 
-	timestamp := GetMetadata(img)
-
-	ans, _ := time.Parse("2006-01-02T15:04:05", "2020-12-11T20:30:03")
-	if timestamp != ans {
-		t.Errorf("Timestamp detected is %q", timestamp)
+	engine := &failingEngine{err: errors.New("engine crashed")}
+	if res, err := ImgToTable(engine, img, log.WithField("image_url", "testdata/sample_img.png")); err == nil {
+		t.Errorf("Table of a failing engine is %q", res)
+	}
+	if ts, err := GetMetadata(engine, img, log.WithField("image_url", "testdata/sample_img.png")); err == nil {
+		t.Errorf("Timestamp of a failing engine is %s", ts)
 	}
 }
 
-func TestImgToTable(t *testing.T) {
-	img := readImg("testdata/sample_img.png")
+func TestImgToTableNoTable(t *testing.T) {
+	blank := image.NewGray(image.Rect(0, 0, 400, 300))
+	for i := range blank.Pix {
+		blank.Pix[i] = 0xff
+	}
+	img, err := ImgToMat(blank)
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer img.Close()
 
-	res := ImgToTable(img)
-
-	if len(res) != 7 {
-		t.Errorf("Should be 7 columns, found %d\n", len(res))
+	engine := &FakeEngine{Text: func(region image.Rectangle, hints Hints) string { return "" }}
+	if res, err := ImgToTable(engine, img, log.WithField("image_url", "blank")); err == nil {
+		t.Errorf("Table of a blank image is %q", res)
 	}
-	for i := range res {
-		if len(res[i]) != 45 {
-			t.Errorf("Should be 45 rows, found %d in column %d\n",
-				len(res[0]), i)
-		}
+	if len(engine.Calls) != 0 {
+		t.Errorf("Recognize was called %d times", len(engine.Calls))
 	}
+	if header, err := GetHeader(5); err == nil {
+		t.Errorf("Header of 5 columns is %q", header)
+	}
+}
+
+// failingEngine is an Engine whose Recognize always fails with err.
+type failingEngine struct {
+	err error
+}
+
+func (e *failingEngine) Recognize(img image.Image, hints Hints) (string, error) {
+	return "", e.err
+}
 
+func (e *failingEngine) Close() error {
+	return nil
 }
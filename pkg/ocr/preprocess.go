@@ -15,6 +15,28 @@ var (
 	black            = color.RGBA{0, 0, 0, 0}
 )
 
+// Layout is the geometry of the SSRSpeed result tables in pixels.
+type Layout struct {
+	RowHeight        int
+	ColWidthAvgSpeed int
+	ColWidthUDPNAT   int
+}
+
+// SetLayout changes the table geometry, e.g. for a new SSRSpeed version.
+// Zero values keep the current settings. It must be called before any OCR
+// is run.
+func SetLayout(l Layout) {
+	if l.RowHeight > 0 {
+		rowHeight = l.RowHeight
+	}
+	if l.ColWidthAvgSpeed > 0 {
+		colWidthAvgSpeed = l.ColWidthAvgSpeed
+	}
+	if l.ColWidthUDPNAT > 0 {
+		colWidthUDPNAT = l.ColWidthUDPNAT
+	}
+}
+
 // GetBorderIndex returns the indices of the rows and columns.
 func getBorderIndex(img gocv.Mat) ([]int, []int) {
 	imgGray := img.Clone()
@@ -50,6 +72,12 @@ func removeColor(img *gocv.Mat, cols []int) {
 	grayMask.CopyTo(img)
 }
 
+// reduceArtifacts smooths out the block noise of lossy compression, which
+// would otherwise show up as specks after thresholding and confuse Tesseract.
+func reduceArtifacts(img gocv.Mat) {
+	gocv.MedianBlur(img, &img, 3)
+}
+
 func convertToGrayscale(img gocv.Mat) {
 	gocv.CvtColor(img, &img, gocv.ColorBGRToGray)
 }
@@ -154,8 +182,3 @@ func drawRowBorders(img *gocv.Mat, rows []int) {
 		gocv.Line(img, image.Point{0, row}, image.Point{img.Cols(), row}, black, 2)
 	}
 }
-
-func cropImage(img gocv.Mat, x0 int, x1 int, y0 int, y1 int) gocv.Mat {
-	rect := image.Rect(x0, y0, x1, y1)
-	return img.Region(rect)
-}
@@ -0,0 +1,48 @@
+// Package tesseract reads text with Tesseract, through the cgo bindings of
+// gosseract.
+package tesseract
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+
+	"github.com/otiai10/gosseract"
+	"github.com/y1zhou/goduyaoss/pkg/ocr"
+)
+
+// Tesseract is the ocr.Engine of Tesseract.
+type Tesseract struct {
+	client *gosseract.Client
+}
+
+// New starts a Tesseract client.
+func New() *Tesseract {
+	return &Tesseract{client: gosseract.NewClient()}
+}
+
+func (t *Tesseract) Recognize(img image.Image, hints ocr.Hints) (string, error) {
+	if err := t.client.SetLanguage(hints.Languages...); err != nil {
+		return "", err
+	}
+	if hints.Mode == ocr.Block {
+		t.client.SetPageSegMode(gosseract.PSM_AUTO)
+	} else {
+		t.client.SetPageSegMode(gosseract.PSM_SINGLE_LINE)
+	}
+	t.client.SetWhitelist(hints.Whitelist)
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return "", fmt.Errorf("can't encode image: %s", err)
+	}
+	if err := t.client.SetImageFromBytes(buf.Bytes()); err != nil {
+		return "", fmt.Errorf("can't send image bytes to Tesseract: %s", err)
+	}
+	return t.client.Text()
+}
+
+func (t *Tesseract) Close() error {
+	return t.client.Close()
+}
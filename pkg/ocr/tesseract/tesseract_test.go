@@ -0,0 +1,98 @@
+package tesseract
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/ocr"
+)
+
+const sampleImg = "../testdata/sample_img.png"
+
+// readGray reads an image in grayscale, without OpenCV.
+func readGray(t *testing.T, path string) *image.Gray {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return gray
+}
+
+func TestRecognize(t *testing.T) {
+	img := readGray(t, sampleImg)
+
+	engine := New()
+	defer engine.Close()
+
+	// Testcase from the "Remarks" column
+	hints := ocr.Hints{Languages: []string{"chi_sim", "eng"}, Mode: ocr.Line}
+	nodeName, err := engine.Recognize(img.SubImage(image.Rect(64, 60, 479, 90)), hints)
+	trueName := "*Ultimate|IEPL-BGP广新01|3.0|INF* - 1063 单端口"
+	if err != nil || nodeName != trueName {
+		t.Errorf("OCR text is %q, but should be %q: %v", nodeName, trueName, err)
+	}
+
+	// Testcase from the "AvgSpeed" column
+	hints.Languages = []string{"eng"}
+	nodeSpeed, err := engine.Recognize(img.SubImage(image.Rect(807, 60, 897, 90)), hints)
+	if err != nil || nodeSpeed != "21.48MB" {
+		t.Errorf("OCR text is %q, but should be 21.48MB: %v", nodeSpeed, err)
+	}
+}
+
+func TestGetMetadata(t *testing.T) {
+	img, _, err := ocr.ReadImage(sampleImg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+
+	engine := New()
+	defer engine.Close()
+	timestamp, err := ocr.GetMetadata(engine, img, log.WithField("image_url", sampleImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ans, _ := time.Parse("2006-01-02T15:04:05", "2020-12-11T20:30:03")
+	if timestamp != ans {
+		t.Errorf("Timestamp detected is %q", timestamp)
+	}
+}
+
+func TestImgToTable(t *testing.T) {
+	img, _, err := ocr.ReadImage(sampleImg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+
+	engine := New()
+	defer engine.Close()
+	res, err := ocr.ImgToTable(engine, img, log.WithField("image_url", sampleImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 7 {
+		t.Errorf("Should be 7 columns, found %d\n", len(res))
+	}
+	for i := range res {
+		if len(res[i]) != 45 {
+			t.Errorf("Should be 45 rows, found %d in column %d\n",
+				len(res[0]), i)
+		}
+	}
+}
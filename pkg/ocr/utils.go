@@ -2,15 +2,17 @@ package ocr
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	_ "image/gif" // GIF decoder for decodeImg
 	"image/png"
 	"io/ioutil"
-	"log"
 	"os"
 	"regexp"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"gocv.io/x/gocv"
 )
 
@@ -29,23 +31,40 @@ func readImg(imgPath string) gocv.Mat {
 	return gocv.IMRead(imgPath, gocv.IMReadColor)
 }
 
-func imgToBytes(img image.Image) []byte {
-	buf := new(bytes.Buffer)
-	err := png.Encode(buf, img)
+// decodeImg reads an image with the decoders of the image package, for the
+// formats that OpenCV can't read such as GIF.
+func decodeImg(imgPath string) (gocv.Mat, error) {
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return gocv.NewMat(), err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
 	if err != nil {
-		log.Fatalf("Error encoding image to bytes: %q", err)
+		return gocv.NewMat(), err
+	}
+	return ImgToMat(img)
+}
+
+func imgToBytes(img image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, fmt.Errorf("error encoding image to bytes: %s", err)
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 // ImgToMat - Takes the `image.Image` from the crawler and convert to `gocv.Mat`.
-func ImgToMat(img image.Image) gocv.Mat {
-	imgBytes := imgToBytes(img)
-	imgMat, err := gocv.IMDecode(imgBytes, gocv.IMReadColor)
+func ImgToMat(img image.Image) (gocv.Mat, error) {
+	imgBytes, err := imgToBytes(img)
 	if err != nil {
-		log.Fatal(err)
+		return gocv.NewMat(), err
+	}
+	imgMat, err := gocv.IMDecode(imgBytes, gocv.IMReadColor)
+	if err == nil && imgMat.Empty() {
+		err = errors.New("OpenCV can't decode the image")
 	}
-	return imgMat
+	return imgMat, err
 }
 
 func cleanTimestamp(s *string) time.Time {
@@ -53,15 +72,18 @@ func cleanTimestamp(s *string) time.Time {
 	sNew := regexTime.ReplaceAllString(*s, `${1}T$2`)
 	res, err := time.Parse("2006-01-02T15:04:05", sNew)
 	if err != nil {
-		log.Printf("Error parsing timestamp in %q\n", *s)
+		log.Debugf("Error parsing timestamp in %q", *s)
 		return time.Time{}
 	}
 	return res
 }
 
 // PrintTable outputs the result table in a nice foramt.
-func PrintTable(t [][]string) {
-	headers := GetHeader(len(t))
+func PrintTable(t [][]string) error {
+	headers, err := GetHeader(len(t))
+	if err != nil {
+		return err
+	}
 	fmt.Printf("%s", headers[0])
 	for i := 1; i < len(headers); i++ {
 		fmt.Printf(",%s", headers[i])
@@ -73,4 +95,5 @@ func PrintTable(t [][]string) {
 		}
 	}
 	fmt.Printf("\n\n================================================================\n\n")
+	return nil
 }
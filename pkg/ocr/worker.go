@@ -1,20 +1,36 @@
 package ocr
 
 import (
+	"context"
+	"fmt"
 	"image"
-	"log"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/y1zhou/goduyaoss/pkg/db"
+	"github.com/y1zhou/goduyaoss/pkg/logging"
+	"github.com/y1zhou/goduyaoss/pkg/metrics"
 	"gocv.io/x/gocv"
 )
 
 // Job defines the OCR task to run
 type Job struct {
+	ID          int64    // set when queued, to correlate the log records of a job
 	NetProvider string   // 电信/联通/移动
 	Provider    string   // service provider from the <h2> title
+	ImageIndex  int      // position of the image among the provider's images
+	URL         string   // where the image was downloaded from, or its path
+	SHA256      string   // hash of the image in the archive, if it was saved
+	RunID       int64    // run that queued the job, see db.Store.StartRun
 	Image       gocv.Mat // image used for OCR
+	Format      string   // format of the source image, e.g. "png" or "jpeg"
+	Replace     bool     // overwrite existing results with the same timestamp
+	Debug       bool     // log the debug output of the preprocessing
+	Saved       func()   // called once the results are in the database, if set
 }
 
 // Result is sent to the queue to be stored in the database.
@@ -25,30 +41,210 @@ type Result struct {
 	Table       [][]string
 }
 
-// AddJob puts jobs to a queue for Worker to process.
-func AddJob(queue chan Job, img image.Image, netProvider string, provider string) {
-	imgMat := ImgToMat(img)
+// lossyFormats are the image formats that may have compression artifacts.
+var lossyFormats = map[string]bool{"jpeg": true, "webp": true}
 
-	queue <- Job{
-		NetProvider: netProvider, Provider: provider, Image: imgMat,
+// lastJobID is the ID of the last queued job.
+var lastJobID int64
+
+// Log returns a log entry with the fields of the job. Debug messages are
+// included if debug output is turned on for the job.
+func (job Job) Log() *log.Entry {
+	return logging.Logger(job.Debug).WithFields(log.Fields{
+		"job_id":       job.ID,
+		"net_provider": job.NetProvider,
+		"provider":     job.Provider,
+		"image_index":  job.ImageIndex,
+		"image_url":    job.URL,
+	})
+}
+
+// AddJob puts jobs to a queue for Worker to process. job.Format is the name
+// of the source image format, as returned by `image.Decode`. An error is
+// returned if the image can't be converted, or if ctx is cancelled before
+// the job could be queued.
+func AddJob(ctx context.Context, queue chan Job, job Job, img image.Image) error {
+	var err error
+	if job.Image, err = ImgToMat(img); err != nil {
+		return err
 	}
+	if lossyFormats[job.Format] {
+		reduceArtifacts(job.Image)
+	}
+
+	return sendJob(ctx, queue, job)
 }
 
-// Worker performs OCR on the tables and save the results to a database.
-func Worker(id int, dbName string, queue chan Job, wg *sync.WaitGroup) {
+// AddFileJob reads an image from disk and puts it to the queue. The results
+// replace any existing rows with the same timestamp, so that old images can
+// be processed again after the OCR is improved.
+func AddFileJob(ctx context.Context, queue chan Job, job Job, imgPath string) error {
+	imgMat, format, err := ReadImage(imgPath)
+	if err != nil {
+		return err
+	}
+
+	job.Image, job.Format, job.Replace = imgMat, format, true
+	if job.URL == "" {
+		job.URL = imgPath
+	}
+	return sendJob(ctx, queue, job)
+}
+
+// ReadImage reads an image file and returns it along with its format, which
+// is guessed from the file extension. Compression artifacts are reduced for
+// lossy formats.
+func ReadImage(imgPath string) (gocv.Mat, string, error) {
+	imgMat := readImg(imgPath)
+	if imgMat.Empty() {
+		imgMat.Close()
+		var err error
+		if imgMat, err = decodeImg(imgPath); err != nil {
+			return imgMat, "", fmt.Errorf("can't read image %q: %s", imgPath, err)
+		}
+	}
+
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(imgPath)), ".")
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if lossyFormats[format] {
+		reduceArtifacts(imgMat)
+	}
+	return imgMat, format, nil
+}
+
+// sendJob waits for room in the queue, unless ctx is cancelled first.
+func sendJob(ctx context.Context, queue chan Job, job Job) error {
+	job.ID = atomic.AddInt64(&lastJobID, 1)
+	select {
+	case queue <- job:
+		metrics.JobsQueued.Inc()
+		job.Log().WithField("stage", "queue").Info("Added to queue")
+		return nil
+	case <-ctx.Done():
+		job.Image.Close()
+		return ctx.Err()
+	}
+}
+
+// Worker performs OCR on the tables with engine and save the results to
+// store. Once ctx is cancelled, the job in progress is finished and saved,
+// but no new jobs are taken from the queue. engine is closed when the worker
+// returns.
+func Worker(ctx context.Context, id int, store db.Store, engine Engine, queue chan Job, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer engine.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.WithField("worker", id).Infof("Stopping: %s", ctx.Err())
+			return
+		case job, ok := <-queue:
+			if !ok {
+				return
+			}
+			if ctx.Err() != nil {
+				job.Image.Close()
+				continue
+			}
+			runJob(id, store, engine, job)
+		}
+	}
+}
+
+// withStage adds the stage of a job and the time since start to entry.
+func withStage(entry *log.Entry, stage string, start time.Time) *log.Entry {
+	return entry.WithFields(log.Fields{
+		"stage":    stage,
+		"duration": time.Since(start).Seconds(),
+	})
+}
+
+func runJob(id int, store db.Store, engine Engine, job Job) {
+	defer job.Image.Close()
+	entry := job.Log().WithField("worker", id)
 
-	for job := range queue {
-		timestamp := GetMetadata(job.Image)
-		lastTime := db.QueryTime(dbName, job.NetProvider, job.Provider)
-		if timestamp.After(lastTime) {
-			log.Printf("[Worker %d] Running OCR on: %s -> %s\n", id, job.NetProvider, job.Provider)
-			jobTable := ImgToTable(job.Image)
-
-			db.InsertRows(dbName, job.NetProvider, job.Provider, timestamp, jobTable)
-			log.Printf("[Worker %d] Results saved: %s -> %s\n", id, job.NetProvider, job.Provider)
-		} else {
-			log.Printf("[Worker %d] %s -> %s is up to date\n", id, job.NetProvider, job.Provider)
+	start := time.Now()
+	timestamp, err := GetMetadata(engine, job.Image, entry)
+	if err != nil {
+		withStage(entry, "metadata", start).Errorf("Error reading timestamp: %s", err)
+		metrics.Failures.WithLabelValues(metrics.StageOCR).Inc()
+		return
+	}
+	if timestamp.IsZero() {
+		withStage(entry, "metadata", start).Warn("No timestamp found")
+		metrics.Failures.WithLabelValues(metrics.StageOCR).Inc()
+		return
+	}
+	entry = entry.WithField("timestamp", timestamp)
+
+	if !job.Replace {
+		lastTime, err := store.LatestTime(job.NetProvider, job.Provider, job.ImageIndex)
+		if err != nil {
+			withStage(entry, "db", start).Errorf("Error querying results: %s", err)
+			metrics.Failures.WithLabelValues(metrics.StageDB).Inc()
+			return
 		}
+		if !timestamp.After(lastTime) {
+			withStage(entry, "metadata", start).Info("Results are up to date")
+			metrics.JobsSkipped.Inc()
+			job.saved()
+			return
+		}
+	}
+
+	start = time.Now()
+	entry.WithField("stage", "ocr").Info("Running OCR")
+	jobTable, err := ImgToTable(engine, job.Image, entry)
+	if err != nil {
+		withStage(entry, "ocr", start).Errorf("Error running OCR: %s", err)
+		metrics.Failures.WithLabelValues(metrics.StageOCR).Inc()
+		return
+	}
+	withStage(entry, "ocr", start).Info("OCR finished")
+
+	start = time.Now()
+	snap := db.Snapshot{
+		NetProvider: job.NetProvider,
+		Provider:    job.Provider,
+		ImageIndex:  job.ImageIndex,
+		Timestamp:   timestamp,
+		Table:       jobTable,
+		RunID:       job.RunID,
+		URL:         job.URL,
+		SHA256:      job.SHA256,
+	}
+	if job.Replace {
+		err = store.ReplaceSnapshot(snap)
+	} else {
+		err = store.InsertSnapshot(snap)
+	}
+	if err == db.ErrExists {
+		// Another worker or run saved the same image in the meantime
+		withStage(entry, "db", start).Info("Results are up to date")
+		metrics.JobsSkipped.Inc()
+		job.saved()
+		return
+	}
+	if err != nil {
+		withStage(entry, "db", start).Errorf("Error saving results: %s", err)
+		metrics.Failures.WithLabelValues(metrics.StageDB).Inc()
+		return
+	}
+	if job.Replace {
+		withStage(entry, "db", start).Info("Results replaced")
+	} else {
+		withStage(entry, "db", start).Info("Results saved")
+	}
+	metrics.JobsProcessed.Inc()
+	job.saved()
+}
+
+// saved calls job.Saved if it's set.
+func (job Job) saved() {
+	if job.Saved != nil {
+		job.Saved()
 	}
 }
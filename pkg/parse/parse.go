@@ -0,0 +1,188 @@
+// Package parse reads the numbers in the result tables from the text
+// returned by OCR.
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrNotTested is returned for the cells of nodes that weren't tested,
+// e.g. "N/A", and for pings and speeds of 0, which failed nodes show.
+var ErrNotTested = errors.New("the node wasn't tested")
+
+// Number is a value read from a cell. Confidence is 1 if the text was a
+// well-formed number, and lower for each fix it needed, e.g. an O read
+// instead of a 0 or a missing decimal point.
+type Number struct {
+	Value      float64
+	Confidence float64
+}
+
+// Confidence of a fix, multiplied for each time it's made.
+const (
+	// a character that looks like a digit, or a comma for a decimal point
+	fixedChar = 0.9
+	// a character that was dropped, or a missing percent sign or unit
+	droppedChar = 0.8
+	// an integer, though SSRSpeed shows 2 decimal places
+	noDecimals = 0.8
+	// a decimal point put before the last 2 digits
+	guessedPoint = 0.5
+)
+
+// lookalikes are read by OCR instead of digits.
+var lookalikes = map[rune]rune{
+	'O': '0', 'o': '0',
+	'l': '1', 'I': '1', '|': '1',
+}
+
+var notTested = regexp.MustCompile(`(?i)^N/?A$`)
+
+// Loss parses a packet loss such as "0.00%".
+func Loss(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	penalty := 1.0
+	if strings.HasSuffix(s, "%") {
+		s = strings.TrimSuffix(s, "%")
+	} else if !notTested.MatchString(s) {
+		penalty = droppedChar
+	}
+
+	n, err := number(s)
+	if err != nil {
+		return n, err
+	}
+	if n.Value > 100 {
+		return Number{}, fmt.Errorf("loss %q is more than 100%%", s)
+	}
+	n.Confidence *= penalty
+	return n, nil
+}
+
+// Ping parses a latency in ms such as "71.45".
+func Ping(s string) (Number, error) {
+	return notZero(number(s))
+}
+
+// notZero returns ErrNotTested instead of a value of 0, as no node has a
+// ping or speed of 0.
+func notZero(n Number, err error) (Number, error) {
+	if err == nil && n.Value == 0 {
+		return Number{}, ErrNotTested
+	}
+	return n, err
+}
+
+// speedUnits are the units at the end of a speed. B comes last as the
+// others end with it, and units without the B are accepted in case OCR
+// missed it.
+var speedUnits = []struct {
+	suffix string
+	scalar float64
+	missed bool
+}{
+	{"KB", 1e3, false},
+	{"MB", 1e6, false},
+	{"GB", 1e9, false},
+	{"B", 1, false},
+	{"K", 1e3, true},
+	{"M", 1e6, true},
+	{"G", 1e9, true},
+}
+
+// Speed parses a speed in bytes/s such as "21.48MB".
+func Speed(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	if notTested.MatchString(s) {
+		return Number{}, ErrNotTested
+	}
+	for _, u := range speedUnits {
+		if !strings.HasSuffix(s, u.suffix) {
+			continue
+		}
+		n, err := notZero(number(strings.TrimSuffix(s, u.suffix)))
+		if err != nil {
+			return n, err
+		}
+		n.Value *= u.scalar
+		if u.missed {
+			n.Confidence *= droppedChar
+		}
+		return n, nil
+	}
+	return Number{}, fmt.Errorf("speed %q has no unit", s)
+}
+
+// number parses a decimal number. The table shows 2 decimal places, so a
+// number of 4 or more digits without a decimal point is taken to have lost
+// it.
+func number(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	if notTested.MatchString(s) {
+		return Number{}, ErrNotTested
+	}
+
+	conf := 1.0
+	hasPoint := strings.Contains(s, ".")
+	digits, dropped := 0, 0
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+			digits++
+		case r == '.':
+			b.WriteRune(r)
+		case lookalikes[r] != 0:
+			b.WriteRune(lookalikes[r])
+			digits++
+			conf *= fixedChar
+		case r == ',':
+			// a decimal point, unless there is one already
+			if !hasPoint {
+				b.WriteRune('.')
+				hasPoint = true
+			}
+			conf *= fixedChar
+		case unicode.IsSpace(r):
+		default:
+			dropped++
+			conf *= droppedChar
+		}
+	}
+	if digits == 0 {
+		return Number{}, fmt.Errorf("no digits in %q", s)
+	}
+	if dropped > digits {
+		return Number{}, fmt.Errorf("too many characters that aren't digits in %q", s)
+	}
+
+	res := b.String()
+	if strings.HasPrefix(res, ".") || strings.HasSuffix(res, ".") {
+		res = strings.Trim(res, ".")
+		conf *= droppedChar
+	}
+	switch {
+	case strings.Count(res, ".") > 1:
+		return Number{}, fmt.Errorf("more than one decimal point in %q", s)
+	case strings.Contains(res, "."):
+	case strings.Trim(res, "0") == "":
+		// nodes that failed the test may show a single 0
+	case len(res) >= 4:
+		res = res[:len(res)-2] + "." + res[len(res)-2:]
+		conf *= guessedPoint
+	default:
+		conf *= noDecimals
+	}
+
+	v, err := strconv.ParseFloat(res, 64)
+	if err != nil {
+		return Number{}, fmt.Errorf("parsing %q: %s", s, err)
+	}
+	return Number{Value: v, Confidence: conf}, nil
+}
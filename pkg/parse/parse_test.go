@@ -0,0 +1,70 @@
+package parse
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var parsers = map[string]func(string) (Number, error){
+	"loss":        Loss,
+	"ping":        Ping,
+	"google_ping": Ping,
+	"avg_speed":   Speed,
+	"max_speed":   Speed,
+}
+
+func TestCells(t *testing.T) {
+	f, err := os.Open("testdata/cells.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" || strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 || parsers[fields[0]] == nil {
+			t.Fatalf("Line %d is %q", line, scanner.Text())
+		}
+		column, text, want := fields[0], fields[1], fields[2]
+
+		res, err := parsers[column](text)
+		switch want {
+		case "not_tested":
+			if err != ErrNotTested {
+				t.Errorf("%s %q is %+v, %v, should be untested", column, text, res, err)
+			}
+			continue
+		case "error":
+			if err == nil || err == ErrNotTested {
+				t.Errorf("%s %q is %+v, %v, should be an error", column, text, res, err)
+			}
+			continue
+		}
+
+		value, err1 := strconv.ParseFloat(want, 64)
+		conf, err2 := strconv.ParseFloat(fields[3], 64)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("Line %d is %q", line, scanner.Text())
+		}
+		if err != nil {
+			t.Errorf("%s %q returned %v", column, text, err)
+		} else if !near(res.Value, value) || !near(res.Confidence, conf) {
+			t.Errorf("%s %q is %+v, should be %v with confidence %v", column, text, res, value, conf)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
@@ -0,0 +1,196 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSpec runs the jobs everyday at 3AM, like the old systemd timer.
+const DefaultSpec = "0 3 * * *"
+
+// DefaultJitter is the maximum random delay added to each run, so that not
+// every instance hits the sites at the same time.
+const DefaultJitter = 15 * time.Minute
+
+// Parse parses a standard 5-field cron expression, or a descriptor such as
+// "@daily" or "@every 12h".
+func Parse(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+type job struct {
+	name     string
+	schedule cron.Schedule
+	run      func(ctx context.Context) error
+	next     time.Time
+}
+
+// Scheduler runs jobs on cron schedules, one at a time. The time of the last
+// run of each job is kept in a state file, and runs that were missed while
+// the scheduler wasn't running are started right away.
+type Scheduler struct {
+	statePath string
+	jitter    time.Duration
+	jobs      []*job
+	rand      *rand.Rand
+	now       func() time.Time
+}
+
+// New creates a scheduler that keeps its state in statePath.
+func New(statePath string, jitter time.Duration) *Scheduler {
+	return &Scheduler{
+		statePath: statePath,
+		jitter:    jitter,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:       time.Now,
+	}
+}
+
+// Add registers a job. Names must be unique, as they identify the job in the
+// state file. A run that returns an error is logged and tried again at the
+// next scheduled time.
+func (s *Scheduler) Add(name string, spec string, run func(ctx context.Context) error) error {
+	for _, j := range s.jobs {
+		if j.name == name {
+			return fmt.Errorf("duplicate job %q", name)
+		}
+	}
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("job %q: %s", name, err)
+	}
+	s.jobs = append(s.jobs, &job{name: name, schedule: schedule, run: run})
+	return nil
+}
+
+// Run blocks and runs the jobs until ctx is cancelled. A job in progress
+// gets the same ctx, and a run that is cancelled or fails isn't recorded, so
+// it is caught up on the next start.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if len(s.jobs) == 0 {
+		return fmt.Errorf("no jobs to run")
+	}
+	state, err := loadState(s.statePath)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	for _, j := range s.jobs {
+		j.next = s.firstRun(j, state[j.name], now)
+		log.WithFields(log.Fields{"job": j.name, "next_run": j.next}).Info("Scheduled job")
+	}
+
+	for {
+		j := s.nextJob()
+		timer := time.NewTimer(j.next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		start := s.now()
+		log.WithField("job", j.name).Info("Running job")
+		err := j.run(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			log.WithField("job", j.name).Errorf("Job failed: %s", err)
+		} else {
+			state[j.name] = start
+			if err := saveState(s.statePath, state); err != nil {
+				log.Errorf("Error saving scheduler state: %s", err)
+			}
+		}
+		j.next = s.nextRun(j, s.now())
+		log.WithFields(log.Fields{
+			"job":      j.name,
+			"duration": s.now().Sub(start).Seconds(),
+			"next_run": j.next,
+		}).Info("Finished job")
+	}
+}
+
+// firstRun returns now if a run was missed since the last one, like
+// Persistent=true of systemd timers. Jobs that never ran wait for their
+// first scheduled time.
+func (s *Scheduler) firstRun(j *job, last time.Time, now time.Time) time.Time {
+	if !last.IsZero() && !j.schedule.Next(last).After(now) {
+		return now
+	}
+	return s.nextRun(j, now)
+}
+
+// nextRun returns the next scheduled time after now plus a random delay.
+func (s *Scheduler) nextRun(j *job, now time.Time) time.Time {
+	next := j.schedule.Next(now)
+	if s.jitter > 0 {
+		next = next.Add(time.Duration(s.rand.Int63n(int64(s.jitter))))
+	}
+	return next
+}
+
+// nextJob returns the job that is due first. Jobs due at the same time run
+// in the order they were added.
+func (s *Scheduler) nextJob() *job {
+	first := s.jobs[0]
+	for _, j := range s.jobs[1:] {
+		if j.next.Before(first.next) {
+			first = j
+		}
+	}
+	return first
+}
+
+// loadState reads the time of the last run of each job. A missing file is
+// an empty state.
+func loadState(path string) (map[string]time.Time, error) {
+	state := make(map[string]time.Time)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return state, nil
+}
+
+// saveState writes the state to a temporary file first, so a crash never
+// leaves a truncated file behind.
+func saveState(path string, state map[string]time.Time) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFirstRun(t *testing.T) {
+	s := New("", 0)
+	if err := s.Add("daily", DefaultSpec, nil); err != nil {
+		t.Fatal(err)
+	}
+	j := s.jobs[0]
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.Local)
+	tomorrow := time.Date(2021, 3, 11, 3, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		name string
+		last time.Time
+		want time.Time
+	}{
+		{"never ran", time.Time{}, tomorrow},
+		{"ran today", time.Date(2021, 3, 10, 3, 5, 0, 0, time.Local), tomorrow},
+		{"missed a run", time.Date(2021, 3, 9, 3, 5, 0, 0, time.Local), now},
+	}
+	for _, c := range cases {
+		if got := s.firstRun(j, c.last, now); !got.Equal(c.want) {
+			t.Errorf("First run when %s is %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	s.jitter = time.Minute
+	for i := 0; i < 10; i++ {
+		got := s.nextRun(j, now)
+		if got.Before(tomorrow) || !got.Before(tomorrow.Add(time.Minute)) {
+			t.Errorf("Next run with jitter is %s", got)
+		}
+	}
+}
+
+func TestAddInvalid(t *testing.T) {
+	s := New("", 0)
+	if err := s.Add("a", "every day", nil); err == nil {
+		t.Error("Invalid spec was accepted")
+	}
+	s.Add("a", "@daily", nil)
+	if err := s.Add("a", "@hourly", nil); err == nil {
+		t.Error("Duplicate job was accepted")
+	}
+}
+
+func TestRunCatchUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+
+	lastRun := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := saveState(statePath, map[string]time.Time{"a": lastRun, "b": lastRun}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ran []string
+	s := New(statePath, time.Hour)
+	s.Add("a", "@daily", func(ctx context.Context) error {
+		ran = append(ran, "a")
+		return nil
+	})
+	s.Add("b", "@daily", func(ctx context.Context) error {
+		ran = append(ran, "b")
+		cancel()
+		return nil
+	})
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 || ran[0] != "a" {
+		t.Fatalf("Jobs that ran: %v", ran)
+	}
+
+	// b was cancelled, so only a is recorded
+	state, err := loadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state["a"].After(lastRun) || !state["b"].Equal(lastRun) {
+		t.Errorf("State after the run is %v", state)
+	}
+}
+
+func TestRunFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+
+	lastRun := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := saveState(statePath, map[string]time.Time{"a": lastRun, "b": lastRun}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ran []string
+	s := New(statePath, time.Hour)
+	s.Add("a", "@daily", func(ctx context.Context) error {
+		ran = append(ran, "a")
+		return errors.New("database is down")
+	})
+	s.Add("b", "@daily", func(ctx context.Context) error {
+		ran = append(ran, "b")
+		cancel()
+		return nil
+	})
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 {
+		t.Fatalf("Jobs that ran: %v", ran)
+	}
+
+	// a failed, so it's caught up on the next start
+	state, err := loadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state["a"].Equal(lastRun) {
+		t.Errorf("State after the run is %v", state)
+	}
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Size of the history charts in pixels, and the margin for the labels.
+const (
+	chartWidth  = 640
+	chartHeight = 160
+	chartMargin = 40
+)
+
+// series collects the points of a chart, leaving out missing values.
+type series struct {
+	times  []time.Time
+	values []float64
+}
+
+func (s *series) add(t time.Time, v *float64) {
+	if v != nil {
+		s.times = append(s.times, t)
+		s.values = append(s.values, *v)
+	}
+}
+
+// lineChart draws values over time as an inline SVG line chart. The y axis
+// starts at 0, and each point shows its value when hovered.
+func lineChart(title string, times []time.Time, values []float64, format func(float64) string) template.HTML {
+	maxValue := 0.0
+	for _, v := range values {
+		if v > maxValue {
+			maxValue = v
+		}
+	}
+	if maxValue == 0 {
+		maxValue = 1
+	}
+
+	plotWidth := float64(chartWidth - 2*chartMargin)
+	plotHeight := float64(chartHeight - 2*chartMargin)
+	x := func(i int) float64 {
+		first, last := times[0], times[len(times)-1]
+		if !last.After(first) {
+			return chartMargin + plotWidth/2
+		}
+		return chartMargin + plotWidth*float64(times[i].Sub(first))/float64(last.Sub(first))
+	}
+	y := func(v float64) float64 {
+		return chartMargin + plotHeight*(1-v/maxValue)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="chart" width="%d" height="%d" viewBox="0 0 %d %d" role="img">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="20" class="title">%s</text>`, chartMargin, template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="axis"/>`,
+		chartMargin, chartHeight-chartMargin, chartWidth-chartMargin, chartHeight-chartMargin)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label" text-anchor="end">%s</text>`,
+		chartMargin-4, chartMargin+4, template.HTMLEscapeString(format(maxValue)))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label" text-anchor="end">%s</text>`,
+		chartMargin-4, chartHeight-chartMargin+4, template.HTMLEscapeString(format(0)))
+
+	if len(values) > 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" class="label">%s</text>`,
+			chartMargin, chartHeight-chartMargin+16, times[0].Format("2006-01-02"))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" class="label" text-anchor="end">%s</text>`,
+			chartWidth-chartMargin, chartHeight-chartMargin+16, times[len(times)-1].Format("2006-01-02"))
+
+		points := make([]string, len(values))
+		for i, v := range values {
+			points[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(v))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" class="line"/>`, strings.Join(points, " "))
+		for i, v := range values {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3"><title>%s: %s</title></circle>`,
+				x(i), y(v), times[i].Format("2006-01-02 15:04"), template.HTMLEscapeString(format(v)))
+		}
+	}
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String())
+}
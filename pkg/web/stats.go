@@ -0,0 +1,105 @@
+package web
+
+import (
+	"sort"
+	"time"
+
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+// ranking is the summary of the latest results of a provider.
+type ranking struct {
+	Provider string
+	Nodes    int
+	AvgSpeed float64 // median in bytes/s
+	Ping     float64 // median of the reachable nodes, 0 if there are none
+	Loss     float64 // median in percent
+	Updated  time.Time
+}
+
+// sortKeys are the columns the rankings can be sorted by. Higher speeds and
+// lower ping and loss rank first.
+var sortKeys = map[string]func(a, b ranking) bool{
+	"speed": func(a, b ranking) bool { return a.AvgSpeed > b.AvgSpeed },
+	"ping": func(a, b ranking) bool {
+		if (a.Ping == 0) != (b.Ping == 0) {
+			return b.Ping == 0
+		}
+		return a.Ping < b.Ping
+	},
+	"loss": func(a, b ranking) bool { return a.Loss < b.Loss },
+}
+
+// rankProviders computes the medians of each provider in rows and sorts
+// them by key. Ties are sorted by name.
+func rankProviders(rows []db.Row, key string) []ranking {
+	type nodes struct {
+		count             int
+		speed, ping, loss []float64
+		updated           time.Time
+	}
+	byProvider := make(map[string]*nodes)
+	for _, r := range rows {
+		n, ok := byProvider[r.Provider]
+		if !ok {
+			n = new(nodes)
+			byProvider[r.Provider] = n
+		}
+		n.count++
+		// Values that weren't read are left out
+		if r.AvgSpeed != nil {
+			n.speed = append(n.speed, *r.AvgSpeed)
+		}
+		if r.Loss != nil {
+			n.loss = append(n.loss, *r.Loss)
+		}
+		// Unreachable nodes have no ping
+		if r.Ping != nil && r.Loss != nil && *r.Loss < 100 {
+			n.ping = append(n.ping, *r.Ping)
+		}
+		if r.Timestamp.After(n.updated) {
+			n.updated = r.Timestamp
+		}
+	}
+
+	res := make([]ranking, 0, len(byProvider))
+	for provider, n := range byProvider {
+		res = append(res, ranking{
+			Provider: provider,
+			Nodes:    n.count,
+			AvgSpeed: median(n.speed),
+			Ping:     median(n.ping),
+			Loss:     median(n.loss),
+			Updated:  n.updated,
+		})
+	}
+
+	less, ok := sortKeys[key]
+	if !ok {
+		less = sortKeys["speed"]
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if less(res[i], res[j]) {
+			return true
+		}
+		if less(res[j], res[i]) {
+			return false
+		}
+		return res[i].Provider < res[j].Provider
+	})
+	return res
+}
+
+// median returns 0 for an empty slice.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,165 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+var layoutHTML = `{{define "layout"}}<!DOCTYPE html>
+<html lang="zh">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - goduyaoss</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 1200px; padding: 0 1em; color: #222; }
+header { border-bottom: 1px solid #ddd; padding: .8em 0; }
+header a { font-weight: bold; text-decoration: none; color: inherit; }
+nav a { margin-right: 1em; }
+nav a.current { font-weight: bold; text-decoration: none; color: inherit; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { padding: .3em .6em; border-bottom: 1px solid #eee; text-align: left; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.result { display: flex; flex-wrap: wrap; gap: 1em; align-items: flex-start; }
+.result img { max-width: 560px; width: 100%; border: 1px solid #ddd; }
+.muted { color: #888; }
+svg.chart { display: block; margin: 1em 0; }
+svg.chart .title { font-weight: bold; font-size: 14px; }
+svg.chart .label { font-size: 11px; fill: #666; }
+svg.chart .axis { stroke: #ccc; }
+svg.chart .line { fill: none; stroke: #2a6fdb; stroke-width: 2; }
+svg.chart circle { fill: #2a6fdb; }
+</style>
+</head>
+<body>
+<header><a href="/">goduyaoss</a></header>
+<main>
+{{template "content" .}}
+</main>
+</body>
+</html>{{end}}`
+
+var indexHTML = `{{define "content"}}
+<nav>
+{{range .NetProviders}}<a href="/?net={{.}}&amp;sort={{$.Sort}}"{{if eq . $.Net}} class="current"{{end}}>{{.}}</a>{{end}}
+</nav>
+{{if .Rankings}}
+<table>
+<thead><tr>
+<th>#</th><th>Provider</th><th>Nodes</th>
+<th><a href="/?net={{.Net}}&amp;sort=speed">Median speed</a></th>
+<th><a href="/?net={{.Net}}&amp;sort=ping">Median ping</a></th>
+<th><a href="/?net={{.Net}}&amp;sort=loss">Median loss</a></th>
+<th>Updated</th>
+</tr></thead>
+<tbody>
+{{range $i, $r := .Rankings}}<tr>
+<td class="num">{{inc $i}}</td>
+<td><a href="/provider?net={{$.Net}}&amp;name={{$r.Provider}}">{{$r.Provider}}</a></td>
+<td class="num">{{$r.Nodes}}</td>
+<td class="num">{{speed $r.AvgSpeed}}</td>
+<td class="num">{{if $r.Ping}}{{ms $r.Ping}}{{else}}<span class="muted">-</span>{{end}}</td>
+<td class="num">{{percent $r.Loss}}</td>
+<td>{{date $r.Updated}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p class="muted">No results yet.</p>
+{{end}}
+{{end}}`
+
+var providerHTML = `{{define "content"}}
+<h1>{{.Provider}} <small class="muted">{{.Net}}</small></h1>
+{{range .Images}}
+<h2>Image #{{.Index}} <small class="muted">generated at {{date .Timestamp}}</small></h2>
+<div class="result">
+{{if .SHA256}}<a href="/images/{{.SHA256}}"><img src="/images/{{.SHA256}}" alt="Result image #{{.Index}} of {{$.Provider}}"></a>
+{{else}}<p class="muted">The original image isn't in the archive.</p>
+{{end}}
+<table>
+<thead><tr><th>Group</th><th>Remarks</th><th>Loss</th><th>Ping</th><th>Google Ping</th><th>AvgSpeed</th><th>MaxSpeed</th><th>UDP NAT Type</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr>
+<td>{{.Group}}</td>
+<td><a href="/node?net={{$.Net}}&amp;provider={{$.Provider}}&amp;remarks={{.Remarks}}">{{.Remarks}}</a></td>
+<td class="num">{{with .Loss}}{{percent .}}{{else}}<span class="muted" title="{{.LossStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .Ping}}{{ms .}}{{else}}<span class="muted" title="{{.PingStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .GooglePing}}{{ms .}}{{else}}<span class="muted" title="{{.GooglePingStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .AvgSpeed}}{{speed .}}{{else}}<span class="muted" title="{{.AvgSpeedStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .MaxSpeed}}{{speed .}}{{else}}<span class="muted" title="{{.MaxSpeedStatus}}">-</span>{{end}}</td>
+<td>{{.UDPNATType}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+</div>
+{{else}}
+<p class="muted">No results for this provider.</p>
+{{end}}
+{{end}}`
+
+var nodeHTML = `{{define "content"}}
+<h1>{{.Remarks}} <small class="muted"><a href="/provider?net={{.Net}}&amp;name={{.Provider}}">{{.Provider}}</a> {{.Net}}</small></h1>
+{{if .Rows}}
+{{.SpeedChart}}
+{{.PingChart}}
+{{.LossChart}}
+<table>
+<thead><tr><th>Time</th><th>Loss</th><th>Ping</th><th>Google Ping</th><th>AvgSpeed</th><th>MaxSpeed</th><th>UDP NAT Type</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr>
+<td>{{date .Timestamp}}</td>
+<td class="num">{{with .Loss}}{{percent .}}{{else}}<span class="muted" title="{{.LossStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .Ping}}{{ms .}}{{else}}<span class="muted" title="{{.PingStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .GooglePing}}{{ms .}}{{else}}<span class="muted" title="{{.GooglePingStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .AvgSpeed}}{{speed .}}{{else}}<span class="muted" title="{{.AvgSpeedStatus}}">-</span>{{end}}</td>
+<td class="num">{{with .MaxSpeed}}{{speed .}}{{else}}<span class="muted" title="{{.MaxSpeedStatus}}">-</span>{{end}}</td>
+<td>{{.UDPNATType}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p class="muted">No results for this node.</p>
+{{end}}
+{{end}}`
+
+var funcs = template.FuncMap{
+	"inc":     func(i int) int { return i + 1 },
+	"speed":   formatSpeed,
+	"ms":      formatMs,
+	"percent": formatPercent,
+	"date":    func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+}
+
+var templates = map[string]*template.Template{
+	"index":    parsePage(indexHTML),
+	"provider": parsePage(providerHTML),
+	"node":     parsePage(nodeHTML),
+}
+
+func parsePage(content string) *template.Template {
+	t := template.Must(template.New("layout").Funcs(funcs).Parse(layoutHTML))
+	return template.Must(t.Parse(content))
+}
+
+// formatSpeed uses the units of the SSRSpeed tables.
+func formatSpeed(bytes float64) string {
+	switch {
+	case bytes >= 1e9:
+		return fmt.Sprintf("%.2fGB", bytes/1e9)
+	case bytes >= 1e6:
+		return fmt.Sprintf("%.2fMB", bytes/1e6)
+	case bytes >= 1e3:
+		return fmt.Sprintf("%.2fKB", bytes/1e3)
+	}
+	return fmt.Sprintf("%.0fB", bytes)
+}
+
+func formatMs(ms float64) string {
+	return fmt.Sprintf("%.2fms", ms)
+}
+
+func formatPercent(p float64) string {
+	return fmt.Sprintf("%.2f%%", p)
+}
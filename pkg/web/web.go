@@ -0,0 +1,220 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/archive"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+// netProviderOrder is the order of the tabs on the index page. Other net
+// providers come after these.
+var netProviderOrder = []string{"移动", "联通", "电信"}
+
+// Server renders the dashboard of the results database. The original
+// result images are shown when an archive is given.
+type Server struct {
+	store   db.Store
+	archive *archive.Archive
+	mux     *http.ServeMux
+}
+
+// New creates the dashboard for the results in store. imgArchive may be
+// nil.
+func New(store db.Store, imgArchive *archive.Archive) *Server {
+	s := &Server{store: store, archive: imgArchive, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", s.index)
+	s.mux.HandleFunc("/provider", s.provider)
+	s.mux.HandleFunc("/node", s.node)
+	s.mux.HandleFunc("/images/", s.image)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type indexPage struct {
+	Title        string
+	NetProviders []string
+	Net          string
+	Sort         string
+	Rankings     []ranking
+}
+
+func (s *Server) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	rows, err := s.store.Latest(db.Filter{})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	page := indexPage{
+		Title:        "Providers",
+		NetProviders: netProviders(rows),
+		Net:          r.URL.Query().Get("net"),
+		Sort:         r.URL.Query().Get("sort"),
+	}
+	if _, ok := sortKeys[page.Sort]; !ok {
+		page.Sort = "speed"
+	}
+	if page.Net == "" && len(page.NetProviders) > 0 {
+		page.Net = page.NetProviders[0]
+	}
+
+	var selected []db.Row
+	for _, row := range rows {
+		if row.NetProvider == page.Net {
+			selected = append(selected, row)
+		}
+	}
+	page.Rankings = rankProviders(selected, page.Sort)
+	render(w, "index", page)
+}
+
+// netProviders returns the net providers in rows in the order of the tabs.
+func netProviders(rows []db.Row) []string {
+	found := make(map[string]bool)
+	for _, row := range rows {
+		found[row.NetProvider] = true
+	}
+	var res []string
+	for _, np := range netProviderOrder {
+		if found[np] {
+			res = append(res, np)
+			delete(found, np)
+		}
+	}
+	var others []string
+	for np := range found {
+		others = append(others, np)
+	}
+	sort.Strings(others)
+	return append(res, others...)
+}
+
+type resultImage struct {
+	Index     int
+	Timestamp time.Time
+	SHA256    string
+	Rows      []db.Row
+}
+
+type providerPage struct {
+	Title    string
+	Net      string
+	Provider string
+	Images   []*resultImage
+}
+
+func (s *Server) provider(w http.ResponseWriter, r *http.Request) {
+	page := providerPage{Net: r.URL.Query().Get("net"), Provider: r.URL.Query().Get("name")}
+	page.Title = page.Provider
+
+	rows, err := s.store.Latest(db.Filter{NetProvider: page.Net, Provider: page.Provider})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	byIndex := make(map[int]*resultImage)
+	for _, row := range rows {
+		// The filter matches parts of provider names
+		if row.Provider != page.Provider {
+			continue
+		}
+		img, ok := byIndex[row.ImageIndex]
+		if !ok {
+			img = &resultImage{Index: row.ImageIndex, Timestamp: row.Timestamp}
+			// The image the rows were read from, if it was archived
+			if s.archive != nil {
+				img.SHA256 = row.SHA256
+			}
+			byIndex[row.ImageIndex] = img
+			page.Images = append(page.Images, img)
+		}
+		img.Rows = append(img.Rows, row)
+	}
+	render(w, "provider", page)
+}
+
+type nodePage struct {
+	Title      string
+	Net        string
+	Provider   string
+	Remarks    string
+	Rows       []db.Row
+	SpeedChart template.HTML
+	PingChart  template.HTML
+	LossChart  template.HTML
+}
+
+func (s *Server) node(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page := nodePage{Net: q.Get("net"), Provider: q.Get("provider"), Remarks: q.Get("remarks")}
+	page.Title = page.Remarks + " - " + page.Provider
+
+	rows, err := s.store.History(page.Net, page.Provider, page.Remarks, time.Time{}, time.Time{})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	page.Rows = rows
+
+	var speed, ping, loss series
+	for _, row := range rows {
+		speed.add(row.Timestamp, row.AvgSpeed)
+		ping.add(row.Timestamp, row.Ping)
+		loss.add(row.Timestamp, row.Loss)
+	}
+	page.SpeedChart = lineChart("AvgSpeed", speed.times, speed.values, formatSpeed)
+	page.PingChart = lineChart("Ping", ping.times, ping.values, formatMs)
+	page.LossChart = lineChart("Loss", loss.times, loss.values, formatPercent)
+	render(w, "node", page)
+}
+
+func (s *Server) image(w http.ResponseWriter, r *http.Request) {
+	if s.archive == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rec, err := s.archive.Get(strings.TrimPrefix(r.URL.Path, "/images/"))
+	if os.IsNotExist(err) || (err == nil && rec.Path == "") {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	// Archived images never change
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, rec.Path)
+}
+
+// render executes the template into a buffer first, so that errors don't
+// leave a half-written page.
+func render(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := templates[name].ExecuteTemplate(&buf, "layout", data); err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	log.Error(err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
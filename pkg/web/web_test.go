@@ -0,0 +1,130 @@
+package web
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y1zhou/goduyaoss/pkg/archive"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+)
+
+var timestamp = time.Date(2020, 12, 11, 20, 30, 3, 0, time.UTC)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestRankProviders(t *testing.T) {
+	rows := []db.Row{
+		{Provider: "a", AvgSpeed: float(10e6), Ping: float(50), Loss: float(0)},
+		{Provider: "a", AvgSpeed: float(20e6), Ping: float(70), Loss: float(0)},
+		{Provider: "a", AvgSpeed: float(0), Ping: float(0), Loss: float(100)},
+		// Values that weren't read don't count
+		{Provider: "a"},
+		{Provider: "b", AvgSpeed: float(5e6), Ping: float(30), Loss: float(10)},
+		{Provider: "c", AvgSpeed: float(0), Ping: float(0), Loss: float(100)},
+	}
+
+	cases := map[string][]string{
+		"speed": {"a", "b", "c"},
+		"ping":  {"b", "a", "c"},
+		"loss":  {"a", "b", "c"},
+	}
+	for key, want := range cases {
+		res := rankProviders(rows, key)
+		var got []string
+		for _, r := range res {
+			got = append(got, r.Provider)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Ranking by %s is %v, should be %v", key, got, want)
+		}
+	}
+
+	a := rankProviders(rows, "speed")[0]
+	if a.Nodes != 4 || a.AvgSpeed != 10e6 || a.Ping != 60 || a.Loss != 0 {
+		t.Errorf("Medians of a are %+v", a)
+	}
+}
+
+func TestServer(t *testing.T) {
+	store, err := db.Open("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	dir, err := ioutil.TempDir("", "goduyaoss-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	imgArchive, err := archive.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	png.Encode(buf, image.NewGray(image.Rect(0, 0, 20, 10)))
+	rec, err := imgArchive.Save(buf.Bytes(), archive.Record{
+		NetProvider: "联通", Provider: "ssrcloud", FetchedAt: timestamp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A newer image in the archive, whose results weren't saved
+	newer := new(bytes.Buffer)
+	png.Encode(newer, image.NewGray(image.Rect(0, 0, 20, 20)))
+	if _, err := imgArchive.Save(newer.Bytes(), archive.Record{
+		NetProvider: "联通", Provider: "ssrcloud", FetchedAt: timestamp.Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tbl := [][]string{{"Group"}, {"HK 01"}, {"0.00%"}, {"5213"}, {"14452"}, {"21.48MB"}}
+	for _, ts := range []time.Time{timestamp.Add(-24 * time.Hour), timestamp} {
+		err := store.InsertSnapshot(db.Snapshot{
+			NetProvider: "联通", Provider: "ssrcloud", Timestamp: ts, Table: tbl, SHA256: rec.SHA256,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New(store, imgArchive)
+	get := func(path string, query url.Values) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", path+"?"+query.Encode(), nil))
+		return rec
+	}
+
+	res := get("/", nil)
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "21.48MB") {
+		t.Errorf("Index page is %d: %s", res.Code, res.Body)
+	}
+
+	res = get("/provider", url.Values{"net": {"联通"}, "name": {"ssrcloud"}})
+	if !strings.Contains(res.Body.String(), `src="/images/`+rec.SHA256+`"`) {
+		t.Errorf("Provider page doesn't show the image: %s", res.Body)
+	}
+
+	res = get("/node", url.Values{"net": {"联通"}, "provider": {"ssrcloud"}, "remarks": {"HK 01"}})
+	if strings.Count(res.Body.String(), "<svg") != 3 || strings.Count(res.Body.String(), "<circle") != 6 {
+		t.Errorf("Node page doesn't show the charts: %s", res.Body)
+	}
+
+	res = get("/images/"+rec.SHA256, nil)
+	if res.Code != http.StatusOK || !bytes.Equal(res.Body.Bytes(), buf.Bytes()) {
+		t.Errorf("Image response is %d", res.Code)
+	}
+	if res = get("/images/zz"+rec.SHA256[2:], nil); res.Code != http.StatusNotFound {
+		t.Errorf("Invalid image path returned %d", res.Code)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/db"
+	"github.com/y1zhou/goduyaoss/pkg/export"
+)
+
+func runQuery(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("query", opts)
+	opts.addDBFlags(fs)
+	opts.parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store := openReadOnly(opts)
+	defer store.Close()
+	rows, err := store.Latest(db.Filter{NetProvider: opts.filter.netProvider, Provider: opts.filter.provider})
+	if err != nil {
+		log.Fatalf("Error querying database: %s", err)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{
+		"net_provider", "provider", "image_index", "timestamp", "group", "remarks",
+		"loss", "ping", "google_ping", "avg_speed", "max_speed", "udp_nat_type",
+	})
+	for _, r := range rows {
+		w.Write([]string{
+			r.NetProvider, r.Provider, strconv.Itoa(r.ImageIndex),
+			r.Timestamp.Format("2006-01-02 15:04:05"), r.Group, r.Remarks,
+			export.FormatFloat(r.Loss), export.FormatFloat(r.Ping), export.FormatFloat(r.GooglePing),
+			export.FormatFloat(r.AvgSpeed), export.FormatFloat(r.MaxSpeed), r.UDPNATType,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
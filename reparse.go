@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func runReparse(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("reparse", opts)
+	opts.addDBFlags(fs)
+	opts.parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	defer lockDB(opts.dbName)()
+	store := openStore(opts)
+	defer store.Close()
+
+	start := time.Now()
+	read, changed, err := store.Reparse(opts.filter.netProvider, opts.filter.provider)
+	if err != nil {
+		log.Fatalf("Error reparsing results: %s", err)
+	}
+	log.WithFields(log.Fields{
+		"read":     read,
+		"changed":  changed,
+		"duration": time.Since(start).Seconds(),
+	}).Info("Reparsed the saved OCR text")
+}
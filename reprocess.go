@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/archive"
+	"github.com/y1zhou/goduyaoss/pkg/ocr"
+)
+
+// isImage reports whether path is an image to reprocess: any format that
+// the archive saves, and .jpeg files in plain directories.
+func isImage(path string) bool {
+	return archive.IsImage(path) || strings.ToLower(filepath.Ext(path)) == ".jpeg"
+}
+
+func runReprocess(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("reprocess", opts)
+	opts.addDBFlags(fs)
+	opts.addWorkersFlag(fs)
+	opts.parse(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	defer lockDB(opts.dbName)()
+	reprocess(ctx, opts, fs.Args())
+}
+
+// reprocess runs OCR on images saved on disk and replaces the matching rows
+// in the database. The directories can be image archives, where the net
+// provider and provider are read from the sidecar records, or plain
+// directories laid out as <dir>/<net provider>/<provider>/<image>.
+func reprocess(ctx context.Context, opts *options, dirs []string) {
+	store := openStore(opts)
+	defer store.Close()
+	runID := startRun(store, "reprocess")
+	defer finishRun(store, runID)
+	queue := make(chan ocr.Job, 5)
+
+	// failures is written by the walker, and read once it's done
+	var failures []failure
+	var wgWalker sync.WaitGroup
+	wgWalker.Add(1)
+	go func() {
+		defer wgWalker.Done()
+		for _, dir := range dirs {
+			err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if info.IsDir() || !isImage(path) {
+					return nil
+				}
+
+				rec, err := imageSource(dir, path)
+				netProvider, provider := rec.NetProvider, rec.Provider
+				if err == nil && !opts.filter.match(netProvider, provider) {
+					return nil
+				}
+				if err == nil {
+					err = ocr.AddFileJob(ctx, queue, ocr.Job{
+						NetProvider: netProvider,
+						Provider:    provider,
+						ImageIndex:  rec.ImageIndex,
+						URL:         rec.URL,
+						SHA256:      rec.SHA256,
+						RunID:       runID,
+						Debug:       debugJob(opts.debug, provider),
+					}, path)
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					log.WithField("image_url", path).Errorf("Skipping image: %s", err)
+					failures = append(failures, failure{netProvider, provider, err})
+				}
+				return nil
+			})
+			if ctx.Err() != nil {
+				break
+			}
+			if err != nil {
+				log.Errorf("Error reading %s: %s", dir, err)
+				failures = append(failures, failure{dir, "", err})
+			}
+		}
+		close(queue)
+	}()
+
+	wgWorker := startWorkers(ctx, opts, store, queue)
+	wgWorker.Wait()
+	wgWalker.Wait()
+	if ctx.Err() != nil {
+		log.Warn("Stopped before all jobs were finished")
+	} else {
+		log.Info("All jobs finished!")
+	}
+
+	reportFailures(failures)
+}
+
+// imageSource finds the net provider, provider and image index of an image,
+// either from its archive sidecar or from the directory structure. Images
+// outside of the archive are assumed to be the provider's first image, and
+// have no URL or hash.
+func imageSource(root string, path string) (archive.Record, error) {
+	if rec, err := archive.Sidecar(path); err == nil {
+		return rec, nil
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return archive.Record{}, err
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 3 {
+		return archive.Record{}, fmt.Errorf("no sidecar record and not in a <net provider>/<provider>/ directory")
+	}
+	return archive.Record{NetProvider: parts[0], Provider: parts[1]}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/y1zhou/goduyaoss/pkg/api"
+	"github.com/y1zhou/goduyaoss/pkg/archive"
+	"github.com/y1zhou/goduyaoss/pkg/metrics"
+	"github.com/y1zhou/goduyaoss/pkg/web"
+)
+
+func runServe(ctx context.Context, args []string) {
+	opts := new(options)
+	fs := newFlagSet("serve", opts)
+	fs.StringVar(&opts.dbName, "db", "test.db", "path to the SQLite database or a postgres:// URL")
+	fs.StringVar(&opts.listen, "listen", "localhost:8080", "address to serve the dashboard and API on")
+	fs.StringVar(&opts.archiveDir, "archive", "archive", "directory of the image archive")
+	opts.parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// The dashboard shows no images without an archive
+	imgArchive, err := archive.Open(opts.archiveDir)
+	if os.IsNotExist(err) {
+		log.Warnf("Archive directory %s doesn't exist, images won't be shown", opts.archiveDir)
+	} else if err != nil {
+		log.Fatalf("Error opening archive directory: %s", err)
+	}
+	store := openReadOnly(opts)
+	defer store.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api.New(store))
+	mux.Handle("/", web.New(store, imgArchive))
+	serve(ctx, opts, mux)
+}
+
+// serveMetrics serves /metrics in the background until ctx is cancelled.
+// Nothing is served if the address is empty.
+func serveMetrics(ctx context.Context, addr string) {
+	if addr == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error serving metrics: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			log.Errorf("Error serving metrics: %s", err)
+		}
+	}()
+	log.Infof("Serving metrics on http://%s/metrics", addr)
+}
+
+// serve runs the HTTP server until ctx is cancelled, then waits for the
+// requests in progress.
+func serve(ctx context.Context, opts *options, handler http.Handler) {
+	srv := &http.Server{Addr: opts.listen, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("Serving %s on http://%s", opts.dbName, opts.listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("Error serving: %s", err)
+	}
+	log.Info("Server stopped")
+}